@@ -0,0 +1,234 @@
+// Command cfg manages the encrypted values of config files: it generates keys, encrypts and
+// decrypts values and re-encrypts the values of files with a new key. It also prints the JSON
+// Schema of the configuration. Run it without arguments for usage.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"grail/sysinfra/cfg/config"
+)
+
+// encrypted matches the encrypted values of a config file, whatever its format
+var encrypted = regexp.MustCompile(regexp.QuoteMeta(config.EncryptedPrefix) + `[A-Za-z0-9+/]+=*`)
+
+const usage = `usage: cfg <command> [arguments]
+
+commands:
+  keygen                                   print a new base64 encoded key
+  encrypt [-key-file F] [VALUE]            encrypt VALUE, or the standard input
+  decrypt [-key-file F] VALUE              decrypt a value
+  decrypt [-key-file F] -file FILE         print FILE with its values decrypted
+  rotate [-key-file F] -new-key-file F FILE...
+                                           re-encrypt the values of FILE with a new key
+  schema [-env-prefix P] [-auto-env]       print the JSON Schema of the configuration
+
+The key is read from -key-file, or from CONFIG_KEY or CONFIG_KEY_FILE.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "keygen":
+		err = keygen()
+	case "encrypt":
+		err = encrypt(args)
+	case "decrypt":
+		err = decrypt(args)
+	case "rotate":
+		err = rotate(args)
+	case "schema":
+		err = schema(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cfg: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func keygen() error {
+	key, err := config.GenerateKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(key))
+	return nil
+}
+
+func encrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "file holding the base64 encoded key")
+	_ = fs.Parse(args)
+	key, err := readKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	var value string
+	switch fs.NArg() {
+	case 0:
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+	case 1:
+		value = fs.Arg(0)
+	default:
+		return fmt.Errorf("encrypt takes a single value")
+	}
+	enc, err := config.Encrypt(value, key)
+	if err != nil {
+		return err
+	}
+	fmt.Println(enc)
+	return nil
+}
+
+func decrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "file holding the base64 encoded key")
+	file := fs.String("file", "", "config file to print decrypted")
+	_ = fs.Parse(args)
+	key, err := readKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	if *file == "" {
+		if fs.NArg() != 1 {
+			return fmt.Errorf("decrypt takes a single value")
+		}
+		plain, err := config.Decrypt(fs.Arg(0), key)
+		if err != nil {
+			return err
+		}
+		fmt.Println(plain)
+		return nil
+	}
+
+	isJSON := strings.EqualFold(filepath.Ext(*file), ".json")
+	text, err := replaceValues(*file, func(value string) (string, error) {
+		plain, err := config.Decrypt(value, key)
+		if err != nil || !isJSON {
+			return plain, err
+		}
+		// keep the document valid: escape the value inside its quotes
+		b, err := json.Marshal(plain)
+		return string(b[1 : len(b)-1]), err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Print(text)
+	return nil
+}
+
+func rotate(args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "file holding the current base64 encoded key")
+	newKeyFile := fs.String("new-key-file", "", "file holding the new base64 encoded key")
+	_ = fs.Parse(args)
+	if *newKeyFile == "" {
+		return fmt.Errorf("rotate requires -new-key-file")
+	}
+	key, err := readKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	newKey, err := readKey(*newKeyFile)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range fs.Args() {
+		text, err := replaceValues(file, func(value string) (string, error) {
+			plain, err := config.Decrypt(value, key)
+			if err != nil {
+				return "", err
+			}
+			return config.Encrypt(plain, newKey)
+		})
+		if err != nil {
+			return err
+		}
+		stat, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, []byte(text), stat.Mode().Perm()); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "rotated %s\n", file)
+	}
+	return nil
+}
+
+// schema prints the JSON Schema of config.Configuration. Services with their own configuration
+// structure generate theirs with config.Schema.
+func schema(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	envPrefix := fs.String("env-prefix", "", "prefix of the environment variables")
+	autoEnv := fs.Bool("auto-env", false, "derive the environment variables of untagged fields")
+	_ = fs.Parse(args)
+	var b []byte
+	var err error
+	if *autoEnv {
+		b, err = config.Schema(&config.Configuration{}, config.EnvPrefix(*envPrefix), config.AutoEnv())
+	} else {
+		b, err = config.Schema(&config.Configuration{}, config.EnvPrefix(*envPrefix))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// readKey reads the key from keyFile, or from the environment if keyFile is empty
+func readKey(keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		key, err := config.KeyFromEnv()
+		if err == nil && key == nil {
+			err = config.ErrNoKey
+		}
+		return key, err
+	}
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return config.ParseKey(string(b))
+}
+
+// replaceValues returns the content of file with its encrypted values replaced by replace
+func replaceValues(file string, replace func(string) (string, error)) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var replaceErr error
+	text := encrypted.ReplaceAllStringFunc(string(b), func(value string) string {
+		result, err := replace(value)
+		if err != nil && replaceErr == nil {
+			replaceErr = fmt.Errorf("%s: %v", file, err)
+		}
+		return result
+	})
+	return text, replaceErr
+}
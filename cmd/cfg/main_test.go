@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"grail/sysinfra/cfg/config"
+)
+
+// writeKey writes a new key to a file of dir and returns the key and the file
+func writeKey(t *testing.T, dir, name string) ([]byte, string) {
+	t.Helper()
+	key, err := config.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return key, file
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, oldKeyFile := writeKey(t, dir, "old.key")
+	newKey, newKeyFile := writeKey(t, dir, "new.key")
+	encrypt := func(value string) string {
+		enc, err := config.Encrypt(value, oldKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return enc
+	}
+	file := filepath.Join(dir, "config.yaml")
+	content := "user: admin\npassword: " + encrypt("s3cret") + "\ntoken: \"" + encrypt("abc") + "\"\n"
+	if err := os.WriteFile(file, []byte(content), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rotate([]string{"-key-file", oldKeyFile, "-new-key-file", newKeyFile, file}); err != nil {
+		t.Fatalf("rotate() error = %v", err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, value := range encrypted.FindAllString(string(b), -1) {
+		plain, err := config.Decrypt(value, newKey)
+		if err != nil {
+			t.Errorf("Decrypt() with the new key error = %v", err)
+		}
+		if _, err := config.Decrypt(value, oldKey); err == nil {
+			t.Errorf("Decrypt() of %s with the old key succeeded", value)
+		}
+		values = append(values, plain)
+	}
+	if got := strings.Join(values, ","); got != "s3cret,abc" {
+		t.Errorf("rotated values = %s, want s3cret,abc", got)
+	}
+	if !strings.HasPrefix(string(b), "user: admin\npassword: ") || !strings.Contains(string(b), "\ntoken: \"") {
+		t.Errorf("rotated file =\n%s\nwant the rest of the file unchanged", b)
+	}
+	if stat, err := os.Stat(file); err != nil {
+		t.Error(err)
+	} else if stat.Mode().Perm() != 0o640 {
+		t.Errorf("rotated file mode = %v, want 0640", stat.Mode().Perm())
+	}
+
+	// rotating again with the old key fails and leaves the file as it is
+	if err := rotate([]string{"-key-file", oldKeyFile, "-new-key-file", newKeyFile, file}); err == nil ||
+		!strings.Contains(err.Error(), "wrong key or corrupted value") {
+		t.Errorf("rotate() with the wrong key error = %v, want a decryption error", err)
+	}
+	if again, _ := os.ReadFile(file); string(again) != string(b) {
+		t.Error("rotate() with the wrong key changed the file")
+	}
+	if err := rotate([]string{"-key-file", oldKeyFile, file}); err == nil {
+		t.Error("rotate() without -new-key-file succeeded, want an error")
+	}
+}
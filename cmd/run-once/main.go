@@ -9,16 +9,13 @@ import (
 
 func main() {
 
-	buildNumber := flag.String("build_number", "", "host name")
-	flag.Parse() // add this line
+	flags := config.NewFlagProvider(flag.CommandLine, &config.Configuration{})
+	flag.Parse()
+	config.PrependDataProvider(flags)
 
 	conf, err := config.Init(config.Defaults(
 		config.Set(config.COMMIT, "xe32sdf"),
 	))
-	if *buildNumber != "" {
-		conf.Build.BuildNumber = *buildNumber
-	}
-
 	if err != nil {
 		log.Fatalf("error initializing configuration: %v", err)
 	}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Environment variables holding the key used to decrypt encrypted values: either the base64
+// encoded key itself or the path of a file that contains it
+const (
+	CONFIG_KEY      = "CONFIG_KEY"
+	CONFIG_KEY_FILE = "CONFIG_KEY_FILE"
+)
+
+// EncryptedPrefix starts the values encrypted by Encrypt. The rest of the value is the base64
+// encoded nonce and AES-256-GCM sealed text.
+const EncryptedPrefix = "enc:v1:"
+
+// KeySize is the size in bytes of encryption keys
+const KeySize = 32
+
+// ErrNoKey is returned when an encrypted value is found but no key was supplied
+var ErrNoKey = errors.New("no decryption key, set " + CONFIG_KEY + " or " + CONFIG_KEY_FILE)
+
+// EncryptionKey is a functional argument you can pass to Init() to set the key used to
+// decrypt the encrypted values of config files and providers, instead of reading it from
+// CONFIG_KEY or CONFIG_KEY_FILE.
+func EncryptionKey(key []byte) func(*initOptions) {
+	return func(o *initOptions) {
+		o.EncryptionKey = key
+	}
+}
+
+// GenerateKey returns a new random key
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParseKey decodes a base64 encoded key, as stored in CONFIG_KEY or a key file
+func ParseKey(text string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %v", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key: expected %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// KeyFromEnv returns the key set in CONFIG_KEY, or read from the file named by
+// CONFIG_KEY_FILE. It returns nil if neither is set.
+func KeyFromEnv() ([]byte, error) {
+	if text, ok := os.LookupEnv(CONFIG_KEY); ok {
+		return ParseKey(text)
+	}
+	if path, ok := os.LookupEnv(CONFIG_KEY_FILE); ok {
+		text, err := readValueFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return ParseKey(text)
+	}
+	return nil, nil
+}
+
+// IsEncrypted reports whether value was encrypted by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, EncryptedPrefix)
+}
+
+// Encrypt encrypts value with AES-256-GCM and returns it with the EncryptedPrefix, ready to be
+// stored in a config file
+func Encrypt(value string, key []byte) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), nil)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plain text of a value returned by Encrypt. Values without the
+// EncryptedPrefix are returned unchanged.
+func Decrypt(value string, key []byte) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if key == nil {
+		return "", ErrNoKey
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt value, wrong key or corrupted value")
+	}
+	return string(plain), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key: expected %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptionKey returns the key set with the EncryptionKey option or in the environment
+func encryptionKey(ops *initOptions) ([]byte, error) {
+	if ops.EncryptionKey != nil {
+		return ops.EncryptionKey, nil
+	}
+	return KeyFromEnv()
+}
+
+// decryptTree decrypts the encrypted strings of a tree returned by one of the parsers
+func decryptTree(data interface{}, key []byte) (interface{}, error) {
+	switch val := data.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			plain, err := decryptTree(child, key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			val[k] = plain
+		}
+	case []interface{}:
+		for i, child := range val {
+			plain, err := decryptTree(child, key)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			val[i] = plain
+		}
+	case string:
+		return Decrypt(val, key)
+	}
+	return data, nil
+}
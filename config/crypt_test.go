@@ -0,0 +1,147 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := testKey(t)
+	for _, value := range []string{"s3cret", "", "multi\nline \"quoted\" välue"} {
+		enc, err := Encrypt(value, key)
+		if err != nil {
+			t.Fatalf("Encrypt(%q) error = %v", value, err)
+		}
+		if !IsEncrypted(enc) || strings.Contains(enc, "s3cret") {
+			t.Errorf("Encrypt(%q) = %q, want an encrypted value", value, enc)
+		}
+		again, _ := Encrypt(value, key)
+		if again == enc {
+			t.Errorf("Encrypt(%q) returned the same value twice, want a new nonce", value)
+		}
+		plain, err := Decrypt(enc, key)
+		if err != nil || plain != value {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q, %v", value, plain, err)
+		}
+	}
+	if plain, err := Decrypt("plain text", nil); err != nil || plain != "plain text" {
+		t.Errorf("Decrypt(plain text) = %q, %v, want the value unchanged", plain, err)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key := testKey(t)
+	enc, err := Encrypt("s3cret", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(enc, EncryptedPrefix))
+	sealed[len(sealed)-1] ^= 1
+	tampered := EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+
+	tests := []struct {
+		name  string
+		value string
+		key   []byte
+		want  string
+	}{
+		{"no key", enc, nil, ErrNoKey.Error()},
+		{"wrong key", enc, testKey(t), "cannot decrypt value, wrong key or corrupted value"},
+		{"tampered", tampered, key, "cannot decrypt value, wrong key or corrupted value"},
+		{"not base64", EncryptedPrefix + "!!!", key, "malformed encrypted value"},
+		{"too short", EncryptedPrefix + "AAAA", key, "malformed encrypted value"},
+		{"short key", enc, key[:16], "invalid key: expected 32 bytes, got 16"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, err := Decrypt(tt.value, tt.key)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Decrypt() = %q, %v, want error %q", plain, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := testKey(t)
+	text := base64.StdEncoding.EncodeToString(key)
+	if got, err := ParseKey(" " + text + "\n"); err != nil || string(got) != string(key) {
+		t.Errorf("ParseKey() = %v, %v, want the key", got, err)
+	}
+	for _, text := range []string{"not base64!", base64.StdEncoding.EncodeToString(key[:16])} {
+		if _, err := ParseKey(text); err == nil {
+			t.Errorf("ParseKey(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+type encryptedConfig struct {
+	Password Secret `json:"password" env:"CTEST_PASSWORD"`
+	Token    string `json:"token" env:"CTEST_TOKEN"`
+	User     string `json:"user"`
+}
+
+// TestLoadEncryptedValues decrypts the values of config files and providers at load time
+func TestLoadEncryptedValues(t *testing.T) {
+	key := testKey(t)
+	encrypt := func(value string) string {
+		enc, err := Encrypt(value, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return enc
+	}
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "config.json")
+	writeFile(t, jsonFile, `{"password":"`+encrypt("from json")+`","user":"`+encrypt("admin")+`"}`)
+	yamlFile := filepath.Join(dir, "config.yaml")
+	writeFile(t, yamlFile, "password: "+encrypt("from yaml")+"\n")
+	t.Setenv("CTEST_TOKEN", encrypt("from env"))
+
+	for _, tt := range []struct {
+		file string
+		want string
+	}{{jsonFile, "from json"}, {yamlFile, "from yaml"}} {
+		l := NewLoader(ConfigFiles(tt.file), EncryptionKey(key))
+		var conf encryptedConfig
+		if err := l.Load(&conf); err != nil {
+			t.Fatalf("Load(%s) error = %v", tt.file, err)
+		}
+		if conf.Password.Reveal() != tt.want || conf.Token != "from env" {
+			t.Errorf("Load(%s) = %q, %q, want the decrypted values", tt.file, conf.Password.Reveal(), conf.Token)
+		}
+		if p, ok := l.ExplainFor(&conf, "token"); !ok || !IsEncrypted(p.Source.Value) {
+			t.Errorf("ExplainFor(token) = %v, want the encrypted value as written", p)
+		}
+	}
+
+	t.Setenv(CONFIG_KEY, base64.StdEncoding.EncodeToString(key))
+	var conf encryptedConfig
+	if err := NewLoader(ConfigFiles(jsonFile), Strict()).Load(&conf); err != nil || conf.User != "admin" {
+		t.Errorf("Load() with the key in %s = %q, %v, want the decrypted value", CONFIG_KEY, conf.User, err)
+	}
+
+	t.Setenv(CONFIG_KEY, base64.StdEncoding.EncodeToString(testKey(t)))
+	err := NewLoader(ConfigFiles(), Strict()).Load(&encryptedConfig{})
+	var errs FieldErrors
+	if !errors.As(err, &errs) || errs[0].Path != "token" ||
+		!strings.Contains(errs[0].Error(), "wrong key or corrupted value") {
+		t.Errorf("Load() with a wrong key error = %v, want the token field", err)
+	}
+	if err := NewLoader(ConfigFiles(jsonFile), Strict()).Load(&encryptedConfig{}); err == nil ||
+		!strings.Contains(err.Error(), "wrong key or corrupted value") {
+		t.Errorf("Load() of a file with a wrong key error = %v, want a decryption error", err)
+	}
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var clockLock sync.RWMutex
+
+// clock returns the current time used by date and time templates without the Clock option
+var clock = time.Now
+
+// SetClock replaces the default clock used by date and time templates when the Clock option is
+// not set. A nil clock restores time.Now.
+func SetClock(now func() time.Time) {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	if now == nil {
+		now = time.Now
+	}
+	clock = now
+}
+
+func currentTime() time.Time {
+	clockLock.RLock()
+	defer clockLock.RUnlock()
+	return clock()
+}
+
+// Clock is a functional argument you can pass to Init(), Load() or NewLoader() to set the
+// current time of the date and time templates, so that tests get deterministic values without
+// affecting other Loaders. For example:
+//
+//	l := NewLoader(Clock(func() time.Time { return time.Date(2024, 3, 31, 9, 0, 0, 0, time.UTC) }))
+func Clock(now func() time.Time) func(*initOptions) {
+	return func(o *initOptions) {
+		o.Clock = now
+	}
+}
+
+// clock returns the clock of the Clock option or the default clock
+func (o *initOptions) clock() func() time.Time {
+	if o.Clock != nil {
+		return o.Clock
+	}
+	return currentTime
+}
+
+// expandsDateTime reports whether the templates of values of type t are expanded: strings and
+// the pointers, slices and maps of strings
+func expandsDateTime(t reflect.Type) bool {
+	for {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+		case reflect.String:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// dateTimeTemplate matches {{kind}}, {{kind:offset}} and either followed by |modifiers
+var dateTimeTemplate = regexp.MustCompile(`\{\{(date|time|datetime|unix|epochms)(?::([^|}]*))?((?:\|[^|}]*)*)\}\}`)
+
+// offsetPart matches one signed amount of a unit offset such as -1M+2d
+var offsetPart = regexp.MustCompile(`([+-]?\d+)([yMwdhms])`)
+
+// defaultLayouts are the layouts of the kinds of templates formatted with a layout
+var defaultLayouts = map[string]string{
+	"date":     "2006-01-02",
+	"time":     "15:04:05",
+	"datetime": time.RFC3339,
+}
+
+// expandDateTime replaces the date and time templates of a string value with the time returned
+// by now:
+//
+//	{{kind[:offset][|modifier]...}}
+//
+// kind is date (2006-01-02), time (15:04:05), datetime (RFC 3339), unix (seconds) or epochms
+// (milliseconds). offset is either y,m,d for date or h,m,s for time, or a sequence of signed
+// amounts with the units y, M (months), w, d, h, m (minutes) and s, e.g. -1M+2d. Modifiers are:
+//   - tz=NAME: the time zone, UTC or an IANA name such as Europe/Paris. Local time by default.
+//   - trunc=UNIT: the start of the year, month, week (Monday), day or hour, applied before the
+//     offset
+//   - layout=LAYOUT: a Go time layout replacing the layout of the kind
+//
+// For example {{datetime:-1d|tz=UTC|trunc=day}} is the start of yesterday in UTC.
+func expandDateTime(data string, now func() time.Time) (string, error) {
+	var err error
+	result := dateTimeTemplate.ReplaceAllStringFunc(data, func(template string) string {
+		if err != nil {
+			return template
+		}
+		var value string
+		value, err = formatTemplate(dateTimeTemplate.FindStringSubmatch(template), now())
+		if err != nil {
+			err = fmt.Errorf("invalid template %s: %v", template, err)
+		}
+		return value
+	})
+	return result, err
+}
+
+// formatTemplate returns the value of a template at the time t from its submatches: kind,
+// offset and modifiers
+func formatTemplate(match []string, t time.Time) (string, error) {
+	kind, offset, modifiers := match[1], match[2], match[3]
+	layout := defaultLayouts[kind]
+	trunc := ""
+	for _, modifier := range strings.Split(modifiers, "|")[1:] {
+		name, arg := modifier, ""
+		if i := strings.IndexByte(modifier, '='); i >= 0 {
+			name, arg = modifier[:i], modifier[i+1:]
+		}
+		switch name {
+		case "tz":
+			loc, err := time.LoadLocation(arg)
+			if err != nil {
+				return "", err
+			}
+			t = t.In(loc)
+		case "trunc":
+			trunc = arg
+		case "layout":
+			if arg == "" {
+				return "", fmt.Errorf("empty layout")
+			}
+			layout = arg
+		default:
+			return "", fmt.Errorf("unknown modifier %q", name)
+		}
+	}
+
+	t, err := truncateTime(t, trunc)
+	if err != nil {
+		return "", err
+	}
+	t, err = addOffset(t, kind, offset)
+	if err != nil {
+		return "", err
+	}
+
+	switch kind {
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case "epochms":
+		return strconv.FormatInt(t.UnixMilli(), 10), nil
+	}
+	return t.Format(layout), nil
+}
+
+// truncateTime returns the start of the unit containing t, in the location of t
+func truncateTime(t time.Time, unit string) (time.Time, error) {
+	y, m, d := t.Date()
+	switch unit {
+	case "":
+		return t, nil
+	case "year":
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, t.Location()), nil
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location()), nil
+	case "week":
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-daysSinceMonday, 0, 0, 0, 0, t.Location()), nil
+	case "day":
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location()), nil
+	case "hour":
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location()), nil
+	}
+	return t, fmt.Errorf("unknown truncation unit %q", unit)
+}
+
+// addOffset adds the offset of a template to t. Calendar units are added with AddDate.
+func addOffset(t time.Time, kind string, offset string) (time.Time, error) {
+	if offset == "" {
+		return t, nil
+	}
+	if strings.Contains(offset, ",") {
+		return addTripleOffset(t, kind, offset)
+	}
+	parts := offsetPart.FindAllStringSubmatchIndex(offset, -1)
+	end := 0
+	for _, part := range parts {
+		if part[0] != end {
+			break
+		}
+		end = part[1]
+	}
+	if len(parts) == 0 || end != len(offset) {
+		return t, fmt.Errorf("invalid offset %q", offset)
+	}
+	for _, part := range parts {
+		n, err := strconv.Atoi(offset[part[2]:part[3]])
+		if err != nil {
+			return t, fmt.Errorf("invalid offset %q", offset)
+		}
+		switch offset[part[4]:part[5]] {
+		case "y":
+			t = t.AddDate(n, 0, 0)
+		case "M":
+			t = t.AddDate(0, n, 0)
+		case "w":
+			t = t.AddDate(0, 0, 7*n)
+		case "d":
+			t = t.AddDate(0, 0, n)
+		case "h":
+			t = t.Add(time.Duration(n) * time.Hour)
+		case "m":
+			t = t.Add(time.Duration(n) * time.Minute)
+		case "s":
+			t = t.Add(time.Duration(n) * time.Second)
+		}
+	}
+	return t, nil
+}
+
+// addTripleOffset adds an offset in the original y,m,d form for dates or h,m,s form for times
+func addTripleOffset(t time.Time, kind string, offset string) (time.Time, error) {
+	valueStrings := strings.Split(offset, ",")
+	if len(valueStrings) != 3 {
+		return t, fmt.Errorf("invalid offset %q", offset)
+	}
+	var values [3]int
+	for i, valueString := range valueStrings {
+		num, err := strconv.Atoi(strings.TrimSpace(valueString))
+		if err != nil && valueString != "" {
+			return t, fmt.Errorf("invalid offset %q", offset)
+		}
+		values[i] = num
+	}
+	switch kind {
+	case "date":
+		return t.AddDate(values[0], values[1], values[2]), nil
+	case "time":
+		return t.Add(time.Duration(values[0])*time.Hour +
+			time.Duration(values[1])*time.Minute +
+			time.Duration(values[2])*time.Second), nil
+	}
+	return t, fmt.Errorf("the y,m,d and h,m,s offsets only apply to date and time")
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseDotenv parses a dotenv file of KEY=value lines, optionally prefixed with export. Keys
+// are matched with json names, ignoring case, and a double underscore separates the levels of
+// nested objects, so LOG_LEVEL sets log_level and BUILD__VERSION sets build.version.
+func parseDotenv(data []byte) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	for i, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		sep := strings.IndexByte(line, '=')
+		if sep <= 0 {
+			return nil, fmt.Errorf("dotenv: line %d: expected KEY=value", i+1)
+		}
+		value, err := unquoteValue(strings.TrimSpace(line[sep+1:]), "#")
+		if err != nil {
+			return nil, fmt.Errorf("dotenv: line %d: %v", i+1, err)
+		}
+
+		names := strings.Split(strings.ToLower(strings.TrimSpace(line[:sep])), "__")
+		obj := root
+		for _, name := range names[:len(names)-1] {
+			child, ok := obj[name].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				obj[name] = child
+			}
+			obj = child
+		}
+		obj[names[len(names)-1]] = value
+	}
+	return root, nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix is a functional argument you can pass to Init() to prefix the keys looked up in the
+// environment, so that services sharing an environment do not collide. With EnvPrefix("MYAPP")
+// the LOG_LEVEL field is read from MYAPP_LOG_LEVEL. Other providers use the keys unchanged.
+func EnvPrefix(prefix string) func(*initOptions) {
+	return func(o *initOptions) {
+		o.EnvPrefix = prefix
+	}
+}
+
+// AutoEnv is a functional argument you can pass to Init() to derive the key of fields that do
+// not have an env tag from their json path, so that Build.Commit is read from BUILD_COMMIT.
+// Field names without a json name are converted from camel case, e.g. MaxConns to MAX_CONNS.
+func AutoEnv() func(*initOptions) {
+	return func(o *initOptions) {
+		o.AutoEnv = true
+	}
+}
+
+// prefixedKey returns key with the specified prefix, separated by an underscore
+func prefixedKey(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	if strings.HasSuffix(prefix, "_") {
+		return prefix + key
+	}
+	return prefix + "_" + key
+}
+
+// envKey derives an environment variable style key from a dotted json path
+func envKey(path string) string {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		parts[i] = strings.ToUpper(snakeCase(part))
+	}
+	return strings.Join(parts, "_")
+}
+
+// snakeCase inserts underscores between the words of a camel case name
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
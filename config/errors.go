@@ -0,0 +1,58 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FieldError describes a configuration value that could not be applied to a field, either
+// because the raw value could not be converted to the field's type or because the provider
+// failed while looking the value up.
+type FieldError struct {
+	// Path is the dotted json path of the field, e.g. build.version
+	Path string
+	// Key is the environment variable style key that was looked up
+	Key string
+	// Value is the raw value that failed to convert. It is empty for provider failures.
+	Value string
+	// Kind is the kind of the target field
+	Kind reflect.Kind
+	// Provider is the name of the provider that supplied the value or failed
+	Provider string
+	// Err is the underlying conversion or provider error
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	var ie *InterpolationError
+	if errors.As(e.Err, &ie) {
+		return fmt.Sprintf("%s (%s): cannot expand %q from %s: %v", e.Path, e.Key, e.Value, e.Provider, e.Err)
+	}
+	if e.Value == "" {
+		return fmt.Sprintf("%s (%s): provider %s failed: %v", e.Path, e.Key, e.Provider, e.Err)
+	}
+	if e.Provider == "" {
+		return fmt.Sprintf("%s (%s): cannot convert %q to %s: %v", e.Path, e.Key, e.Value, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s (%s): cannot convert %q from %s to %s: %v",
+		e.Path, e.Key, e.Value, e.Provider, e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying conversion or provider error
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors is the aggregated error returned by ApplyExternalConfig. It contains one entry
+// for every field that could not be resolved.
+type FieldErrors []*FieldError
+
+func (e FieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d configuration error(s): %s", len(e), strings.Join(msgs, "; "))
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errUnavailable = errors.New("store unavailable")
+
+// failingProvider fails to look key up and does not have any other key
+type failingProvider struct {
+	key string
+}
+
+func (p failingProvider) Get(key string) (string, error) {
+	if key == p.key {
+		return "", errUnavailable
+	}
+	return "", nil
+}
+
+func (p failingProvider) Name() string {
+	return "store"
+}
+
+type aggregatedConfig struct {
+	Port    int           `json:"port" env:"ETEST_PORT"`
+	Timeout time.Duration `json:"timeout" env:"ETEST_TIMEOUT"`
+	Region  string        `json:"region" env:"ETEST_REGION"`
+	Name    string        `json:"name" env:"ETEST_NAME"`
+}
+
+// TestFieldErrorsAggregated reports the conversion errors and the provider failures of all the
+// fields at once, and applies the values of the other fields
+func TestFieldErrorsAggregated(t *testing.T) {
+	t.Setenv("ETEST_PORT", "abc")
+	t.Setenv("ETEST_TIMEOUT", "soon")
+	t.Setenv("ETEST_NAME", "api")
+	l := NewLoader(ConfigFiles())
+	l.AddDataProvider(failingProvider{key: "ETEST_REGION"})
+
+	var conf aggregatedConfig
+	err := l.Load(&conf)
+	var errs FieldErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Load() error = %v, want FieldErrors", err)
+	}
+	want := []struct {
+		path, value, provider string
+		kind                  reflect.Kind
+	}{
+		{"port", "abc", "environment", reflect.Int},
+		{"timeout", "soon", "environment", reflect.Int64},
+		{"region", "", "store", reflect.String},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Load() error = %v, want %d field errors", err, len(want))
+	}
+	for i, w := range want {
+		e := errs[i]
+		if e.Path != w.path || e.Value != w.value || e.Provider != w.provider || e.Kind != w.kind {
+			t.Errorf("errs[%d] = %+v, want %+v", i, *e, w)
+		}
+		if !strings.Contains(err.Error(), e.Error()) {
+			t.Errorf("Load() error = %q, want it to contain %q", err, e)
+		}
+	}
+	if !strings.HasPrefix(errs.Error(), "3 configuration error(s): port (ETEST_PORT): cannot convert \"abc\"") {
+		t.Errorf("FieldErrors = %q, want the errors of all fields", errs)
+	}
+	if !errors.Is(errs[2], errUnavailable) {
+		t.Errorf("errs[2] = %v, want it to wrap the provider error", errs[2])
+	}
+	if want := "region (ETEST_REGION): provider store failed: store unavailable"; errs[2].Error() != want {
+		t.Errorf("errs[2] = %q, want %q", errs[2], want)
+	}
+	if conf.Name != "api" {
+		t.Errorf("name = %q, want the value of the field without errors", conf.Name)
+	}
+}
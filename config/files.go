@@ -0,0 +1,120 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"grail/sysinfra/cfg/log"
+)
+
+const (
+	// APP_ENV selects the environment specific config file, e.g. config.production.json
+	APP_ENV = "APP_ENV"
+	// CONFIG_FILE overrides the path of the base config file
+	CONFIG_FILE = "CONFIG_FILE"
+	// CONFIG_DIR overrides the directory of config file fragments
+	CONFIG_DIR = "CONFIG_DIR"
+)
+
+const (
+	defaultConfigFile = "./config.json"
+	defaultConfigDir  = "./config.d"
+)
+
+// ConfigFiles is a functional argument you can pass to Init() to replace the layered stack of
+// config files with an explicit list. Files are merged in order, so later files override the
+// values of earlier ones. Calling it without paths disables config files.
+func ConfigFiles(paths ...string) func(*initOptions) {
+	return func(o *initOptions) {
+		o.ConfigFiles = append([]string{}, paths...)
+	}
+}
+
+// ConfigDir is a functional argument you can pass to Init() to change the directory whose
+// files are merged after the other config files.
+func ConfigDir(dir string) func(*initOptions) {
+	return func(o *initOptions) {
+		o.ConfigDir = dir
+	}
+}
+
+// configFiles returns the config files to read in increasing order of priority. Unless the
+// list was set with ConfigFiles it is made of
+//   - the base file, ./config.json or $CONFIG_FILE
+//   - the environment file next to it, e.g. config.$APP_ENV.json
+//   - the local overrides file next to it, e.g. config.local.json
+//   - the files in ./config.d or $CONFIG_DIR, sorted by name
+func configFiles(ops *initOptions) []string {
+	if ops.ConfigFiles != nil {
+		return ops.ConfigFiles
+	}
+
+	base := os.Getenv(CONFIG_FILE)
+	if base == "" {
+		base = defaultConfigFile
+	}
+	files := []string{base}
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	if appEnv := os.Getenv(APP_ENV); appEnv != "" {
+		files = append(files, stem+"."+appEnv+ext)
+	}
+	files = append(files, stem+".local"+ext)
+
+	dir := ops.ConfigDir
+	if dir == "" {
+		dir = os.Getenv(CONFIG_DIR)
+	}
+	if dir == "" {
+		dir = defaultConfigDir
+	}
+	return append(files, dirConfigFiles(dir)...)
+}
+
+// dirConfigFiles returns the config files in dir sorted by name
+func dirConfigFiles(dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("cannot read config directory %s: %v", dir, err)
+		}
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !isConfigFile(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	return files
+}
+
+// isConfigFile reports whether name has the extension of a supported config file format
+func isConfigFile(name string) bool {
+	_, ok := formatsByExtension[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// initFromConfigFileStack reads the config files into conf in order, so that each file
+// overrides the values of the files before it. Nested objects are merged field by field. The
+// values read are returned by dotted json path, with the highest priority file first. Files
+// with an unknown extension are read in the ConfigFormat of ops. In Strict mode the first file
+// that cannot be read fails the stack, otherwise it is skipped.
+func initFromConfigFileStack(files []string, ops *initOptions, conf interface{}) (map[string][]Source, error) {
+	fileValues := make(map[string][]Source)
+	for _, file := range files {
+		values, err := initFromConfigFile(file, fileFormat(file, ops.ConfigFormat), ops, conf)
+		if err != nil {
+			if ops.Strict {
+				return nil, err
+			}
+			log.Errorf("%v", err)
+			continue
+		}
+		addFileValues(fileValues, file, values)
+	}
+	return fileValues, nil
+}
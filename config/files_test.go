@@ -0,0 +1,41 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+type fileStackConfig struct {
+	A    int               `json:"a"`
+	B    int               `json:"b"`
+	C    int               `json:"c"`
+	Tags map[string]string `json:"tags"`
+}
+
+// TestSkippedFileLeavesConfig reads a file with a value that cannot be converted after a valid
+// one: none of the values of the skipped file are applied, whatever the order of its keys.
+func TestSkippedFileLeavesConfig(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "config.json")
+	writeFile(t, base, `{"a":1,"b":2,"c":3,"tags":{"env":"dev"}}`)
+	bad := filepath.Join(dir, "config.local.json")
+	writeFile(t, bad, `{"a":10,"b":"twenty","c":30,"tags":{"env":"prod","team":"core"}}`)
+
+	for i := 0; i < 20; i++ {
+		l := NewLoader(ConfigFiles(base, bad))
+		var conf fileStackConfig
+		if err := l.Load(&conf); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if conf.A != 1 || conf.B != 2 || conf.C != 3 || len(conf.Tags) != 1 || conf.Tags["env"] != "dev" {
+			t.Fatalf("Load() = %+v, want the values of %s only", conf, base)
+		}
+		if p, ok := l.ExplainFor(&conf, "a"); !ok || p.Source.Name != base {
+			t.Fatalf("ExplainFor(a) = %v, want %s as source", p, base)
+		}
+	}
+
+	if err := NewLoader(ConfigFiles(base, bad), Strict()).Load(&fileStackConfig{}); err == nil {
+		t.Error("Load() in strict mode succeeded, want the conversion error")
+	}
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"flag"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FlagProvider is used to update the configuration from command line flags. A flag is
+// registered for every field with an env tag, and only the flags set on the command line
+// supply values, so it is meant to be the highest priority provider:
+//
+//	flags := config.NewFlagProvider(flag.CommandLine, &config.Configuration{})
+//	flag.Parse()
+//	config.PrependDataProvider(flags)
+//	conf, err := config.Init()
+type FlagProvider struct {
+	flags *flag.FlagSet
+	// names maps env keys to flag names
+	names map[string]string
+}
+
+// NewFlagProvider walks the configuration structure s points to and registers a flag on fs for
+// every field with an env tag. The flag is named after the json name of the field, or its
+// dotted json path if the name is already taken. Its usage comes from the desc tag and its
+// default value from the default tag, except for secret fields.
+func NewFlagProvider(fs *flag.FlagSet, s interface{}) *FlagProvider {
+	f := &FlagProvider{flags: fs, names: make(map[string]string)}
+	f.registerFlags(reflect.TypeOf(s).Elem(), "", 4)
+	return f
+}
+
+func (f *FlagProvider) registerFlags(t reflect.Type, path string, maxDepth int) {
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		key := ft.Tag.Get("env")
+		if key == "" {
+			if maxDepth > 0 && ft.Type.PkgPath() != "" && ft.Type.Kind() == reflect.Struct {
+				f.registerFlags(ft.Type, fieldPath(path, ft), maxDepth-1)
+			}
+			continue
+		}
+		if _, ok := f.names[key]; ok {
+			continue
+		}
+
+		fieldPath := fieldPath(path, ft)
+		name := fieldPath[strings.LastIndex(fieldPath, ".")+1:]
+		if f.flags.Lookup(name) != nil {
+			name = fieldPath
+		}
+		if f.flags.Lookup(name) != nil {
+			continue
+		}
+		usage := ft.Tag.Get("desc")
+		if usage == "" {
+			usage = "sets " + key
+		}
+		defaultValue := ft.Tag.Get("default")
+		if isSecret(ft) {
+			// the default value would be shown by -help
+			defaultValue = ""
+		}
+		if ft.Type.Kind() == reflect.Bool {
+			b, _ := strconv.ParseBool(defaultValue)
+			f.flags.Bool(name, b, usage)
+		} else {
+			f.flags.String(name, defaultValue, usage)
+		}
+		f.names[key] = name
+	}
+}
+
+// Get fetches the value of the flag registered for the specified key. An empty string
+// is returned if the flag was not set on the command line.
+func (f *FlagProvider) Get(key string) (string, error) {
+	value, _, err := f.Lookup(key)
+	return value, err
+}
+
+// Lookup fetches the value of the flag registered for the specified key and reports whether
+// the flag was set on the command line.
+func (f *FlagProvider) Lookup(key string) (string, bool, error) {
+	name, ok := f.names[key]
+	if !ok || !f.flags.Parsed() {
+		return "", false, nil
+	}
+	var value string
+	var found bool
+	f.flags.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			value, found = fl.Value.String(), true
+		}
+	})
+	return value, found, nil
+}
+
+// Name identifies the provider in errors
+func (f *FlagProvider) Name() string {
+	return "flags"
+}
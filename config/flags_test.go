@@ -0,0 +1,108 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+type flagServer struct {
+	Port int `json:"port" env:"ADMIN_PORT" default:"9090"`
+}
+
+type flagConfig struct {
+	Port     int        `json:"port" env:"FTEST_PORT" default:"8080" desc:"port to listen on"`
+	Host     string     `json:"host" env:"FTEST_HOST"`
+	Verbose  bool       `json:"verbose" env:"FTEST_VERBOSE" default:"true"`
+	Password Secret     `json:"password" env:"FTEST_PASSWORD" default:"changeme"`
+	Admin    flagServer `json:"admin"`
+	Region   string     `json:"region"`
+}
+
+func newFlagSet(conf interface{}) (*flag.FlagSet, *FlagProvider) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs, NewFlagProvider(fs, conf)
+}
+
+func TestFlagProviderFlags(t *testing.T) {
+	fs, _ := newFlagSet(&flagConfig{})
+	tests := []struct {
+		name, usage, defaultValue string
+	}{
+		{"port", "port to listen on", "8080"},
+		{"host", "sets FTEST_HOST", ""},
+		{"verbose", "sets FTEST_VERBOSE", "true"},
+		{"password", "sets FTEST_PASSWORD", ""},
+		{"admin.port", "sets ADMIN_PORT", "9090"},
+	}
+	count := 0
+	fs.VisitAll(func(*flag.Flag) { count++ })
+	if count != len(tests) {
+		t.Errorf("%d flags registered, want %d", count, len(tests))
+	}
+	for _, tt := range tests {
+		fl := fs.Lookup(tt.name)
+		if fl == nil {
+			t.Errorf("flag -%s not registered", tt.name)
+			continue
+		}
+		if fl.Usage != tt.usage || fl.DefValue != tt.defaultValue {
+			t.Errorf("flag -%s = %q, default %q, want %q, default %q",
+				tt.name, fl.Usage, fl.DefValue, tt.usage, tt.defaultValue)
+		}
+	}
+	if err := fs.Parse([]string{"-verbose=false"}); err != nil {
+		t.Errorf("Parse() of a bool flag error = %v", err)
+	}
+}
+
+func TestFlagProviderLookup(t *testing.T) {
+	fs, flags := newFlagSet(&flagConfig{})
+	if _, found, _ := flags.Lookup("FTEST_PORT"); found {
+		t.Error("Lookup() found a value before the flags are parsed")
+	}
+	if err := fs.Parse([]string{"-host", "", "-admin.port", "9191", "-verbose=false"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key   string
+		value string
+		found bool
+	}{
+		{"FTEST_PORT", "", false},
+		{"FTEST_HOST", "", true},
+		{"FTEST_VERBOSE", "false", true},
+		{"ADMIN_PORT", "9191", true},
+		{"FTEST_REGION", "", false},
+	}
+	for _, tt := range tests {
+		value, found, err := flags.Lookup(tt.key)
+		if value != tt.value || found != tt.found || err != nil {
+			t.Errorf("Lookup(%s) = %q, %v, %v, want %q, %v", tt.key, value, found, err, tt.value, tt.found)
+		}
+	}
+}
+
+// TestFlagProviderPriority overrides the environment with the flags set on the command line
+// only: the default values of the other flags do not hide lower priority sources.
+func TestFlagProviderPriority(t *testing.T) {
+	t.Setenv("FTEST_PORT", "7070")
+	t.Setenv("FTEST_HOST", "env.example.com")
+	var conf flagConfig
+	fs, flags := newFlagSet(&conf)
+	if err := fs.Parse([]string{"-host", "flag.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLoader(ConfigFiles())
+	l.PrependDataProvider(flags)
+	if err := l.Load(&conf); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if conf.Host != "flag.example.com" || conf.Port != 7070 || !conf.Verbose || conf.Admin.Port != 9090 {
+		t.Errorf("Load() = %+v, want the host of the flags and the port of the environment", conf)
+	}
+	if p, ok := l.ExplainFor(&conf, "host"); !ok || p.Source.Name != "flags" || len(p.Shadowed) != 1 {
+		t.Errorf("ExplainFor(host) = %v, want the flags over the environment", p)
+	}
+}
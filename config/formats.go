@@ -0,0 +1,274 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config file formats
+const (
+	FormatJSON   = "json"
+	FormatYAML   = "yaml"
+	FormatTOML   = "toml"
+	FormatINI    = "ini"
+	FormatDotenv = "dotenv"
+)
+
+// formatsByExtension maps file extensions to config file formats
+var formatsByExtension = map[string]string{
+	".json": FormatJSON,
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+	".toml": FormatTOML,
+	".ini":  FormatINI,
+	".env":  FormatDotenv,
+}
+
+// parsers convert the content of a config file to a tree of map[string]interface{},
+// []interface{} and string values. The JSON parser keeps numbers as json.Number and booleans
+// as bool, so that the values decoded by UnmarshalJSON are encoded again as written.
+var parsers = map[string]func(data []byte) (map[string]interface{}, error){
+	FormatJSON:   parseJSON,
+	FormatYAML:   parseYAML,
+	FormatTOML:   parseTOML,
+	FormatINI:    parseINI,
+	FormatDotenv: parseDotenv,
+}
+
+// ConfigFormat is a functional argument you can pass to Init() to set the format of config
+// files whose extension is not one of .json, .yaml, .yml, .toml, .ini or .env. The format is
+// one of FormatJSON, FormatYAML, FormatTOML, FormatINI or FormatDotenv.
+func ConfigFormat(format string) func(*initOptions) {
+	return func(o *initOptions) {
+		o.ConfigFormat = format
+	}
+}
+
+// fileFormat returns the format of the config file, detected by its extension, or
+// defaultFormat if the extension is unknown
+func fileFormat(filePath string, defaultFormat string) string {
+	if format, ok := formatsByExtension[strings.ToLower(filepath.Ext(filePath))]; ok {
+		return format
+	}
+	if defaultFormat != "" {
+		return defaultFormat
+	}
+	return FormatJSON
+}
+
+// parseJSON parses a JSON document whose root is an object. Numbers are returned as json.Number
+// and null values as nil.
+func parseJSON(data []byte) (map[string]interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var doc interface{}
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON document")
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the JSON document must be an object")
+	}
+	return root, nil
+}
+
+// decodeTree stores a tree returned by one of the parsers into v. Object keys are matched with
+// the json names of struct fields, ignoring case, and scalars are converted with the same
+// setters as provider values. Objects and lists stored in a json.Unmarshaler, such as
+// json.RawMessage, are encoded to JSON and passed to UnmarshalJSON. Date and time templates
+// are expanded at the time returned by now.
+func decodeTree(v reflect.Value, data interface{}, path string, now func() time.Time) error {
+	if data == nil {
+		return nil
+	}
+	switch val := data.(type) {
+	case json.Number:
+		data = val.String()
+	case bool:
+		data = strconv.FormatBool(val)
+	case map[string]interface{}, []interface{}:
+		if isJSONLeaf(v.Type()) {
+			return decodeJSONLeaf(v, data, path)
+		}
+	}
+	switch val := data.(type) {
+	case map[string]interface{}:
+		return decodeObject(v, val, path, now)
+	case []interface{}:
+		return decodeList(v, val, path, now)
+	case string:
+		if expandsDateTime(v.Type()) {
+			expanded, err := expandDateTime(val, now)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			val = expanded
+		}
+		if err := setFieldValue(v, val, ","); err != nil {
+			return fmt.Errorf("%s: cannot convert %q to %s: %v", path, val, v.Type(), err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s: unexpected value %v", path, data)
+	}
+}
+
+func decodeObject(v reflect.Value, obj map[string]interface{}, path string, now func() time.Time) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeObject(v.Elem(), obj, path, now)
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for key, child := range obj {
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := setFieldValue(k, key, ","); err != nil {
+				return fmt.Errorf("%s: invalid key %q: %v", path, key, err)
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if existing := v.MapIndex(k); existing.IsValid() {
+				elem.Set(existing)
+			}
+			if err := decodeTree(elem, child, joinPath(path, key), now); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, elem)
+		}
+		return nil
+	case reflect.Struct:
+		fields := jsonFields(v.Type())
+		for key, child := range obj {
+			index, ok := fields[strings.ToLower(key)]
+			if !ok {
+				continue
+			}
+			if err := decodeTree(v.FieldByIndex(index), child, joinPath(path, key), now); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%s: cannot store an object in a field of type %s", path, v.Type())
+}
+
+func decodeList(v reflect.Value, list []interface{}, path string, now func() time.Time) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeList(v.Elem(), list, path, now)
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), len(list), len(list))
+		for i, item := range list {
+			if err := decodeTree(slice.Index(i), item, fmt.Sprintf("%s[%d]", path, i), now); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Array:
+		// as encoding/json does, extra items are ignored and missing ones are zero
+		array := reflect.New(v.Type()).Elem()
+		for i, item := range list {
+			if i == array.Len() {
+				break
+			}
+			if err := decodeTree(array.Index(i), item, fmt.Sprintf("%s[%d]", path, i), now); err != nil {
+				return err
+			}
+		}
+		v.Set(array)
+		return nil
+	}
+	return fmt.Errorf("%s: cannot store a list in a field of type %s", path, v.Type())
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// isJSONLeaf reports whether values of type t, or of the type t points to, are decoded as a
+// whole by their UnmarshalJSON method, like json.RawMessage, rather than field by field
+func isJSONLeaf(t reflect.Type) bool {
+	t = indirectType(t)
+	return !isLeaf(t) && reflect.PtrTo(t).Implements(jsonUnmarshalerType)
+}
+
+// decodeJSONLeaf stores an object or a list into v, whose type is a JSON leaf, by passing its
+// JSON encoding to UnmarshalJSON
+func decodeJSONLeaf(v reflect.Value, data interface{}, path string) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	b, err := json.Marshal(data)
+	if err == nil {
+		err = v.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(b)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: cannot convert %s to %s: %v", path, b, v.Type(), err)
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// jsonFields returns the index of the fields of the struct type t by lower case json name.
+// Fields of embedded structs without a json name are promoted, as with encoding/json.
+func jsonFields(t reflect.Type) map[string][]int {
+	fields := make(map[string][]int)
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		name := strings.Split(ft.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" && ft.Anonymous && ft.Type.Kind() == reflect.Struct &&
+			!isLeaf(ft.Type) && !isJSONLeaf(ft.Type) {
+			for key, index := range jsonFields(ft.Type) {
+				if _, ok := fields[key]; !ok {
+					fields[key] = append([]int{i}, index...)
+				}
+			}
+			continue
+		}
+		if ft.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = ft.Name
+		}
+		fields[strings.ToLower(name)] = []int{i}
+	}
+	return fields
+}
+
+// treeToJSON returns the json encoding of a parsed tree, used to record the values read
+func treeToJSON(tree map[string]interface{}) []byte {
+	b, _ := json.Marshal(tree)
+	return b
+}
+
+func joinPath(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// point is read from a [x, y] list by UnmarshalJSON
+type point struct {
+	X, Y int
+}
+
+func (p *point) UnmarshalJSON(b []byte) error {
+	var xy []int
+	if err := json.Unmarshal(b, &xy); err != nil {
+		return err
+	}
+	if len(xy) != 2 {
+		return fmt.Errorf("expected [x, y], got %s", b)
+	}
+	p.X, p.Y = xy[0], xy[1]
+	return nil
+}
+
+type jsonLeafConfig struct {
+	Raw    json.RawMessage `json:"raw"`
+	Origin *point          `json:"origin"`
+	Grid   [3]int          `json:"grid"`
+	N      int             `json:"n"`
+}
+
+// TestDecodeJSONLeaves reads fields that encoding/json decodes as a whole, and fixed size
+// arrays, from a JSON config file
+func TestDecodeJSONLeaves(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	writeFile(t, file, `{"raw":{"b":true,"a":[1,2.50]},"origin":[3,4],"grid":[1,2],"n":5}`)
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%v", strict), func(t *testing.T) {
+			options := []func(*initOptions){ConfigFiles(file)}
+			if strict {
+				options = append(options, Strict())
+			}
+			l := NewLoader(options...)
+			var conf jsonLeafConfig
+			if err := l.Load(&conf); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if got, want := string(conf.Raw), `{"a":[1,2.50],"b":true}`; got != want {
+				t.Errorf("raw = %s, want %s", got, want)
+			}
+			if conf.Origin == nil || *conf.Origin != (point{3, 4}) {
+				t.Errorf("origin = %v, want {3 4}", conf.Origin)
+			}
+			if conf.Grid != [3]int{1, 2, 0} {
+				t.Errorf("grid = %v, want [1 2 0]", conf.Grid)
+			}
+			if conf.N != 5 {
+				t.Errorf("n = %d, want 5", conf.N)
+			}
+			if p, ok := l.ExplainFor(&conf, "raw"); !ok || p.Source.Value != `{"a":[1,2.50],"b":true}` {
+				t.Errorf("ExplainFor(raw) = %v, %v, want the object as value", p, ok)
+			}
+		})
+	}
+}
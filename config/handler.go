@@ -0,0 +1,394 @@
+package config
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"grail/sysinfra/cfg/log"
+)
+
+// maxPatchSize limits the size of PATCH /config requests
+const maxPatchSize = 1 << 20
+
+// patchSource names the source of the values set by PATCH /config in the provenance
+const patchSource = "PATCH /config"
+
+type handlerOptions struct {
+	authorize func(r *http.Request) bool
+}
+
+// BearerToken is a functional argument you can pass to Handler() to accept PATCH requests
+// with an "Authorization: Bearer <token>" header
+func BearerToken(token string) func(*handlerOptions) {
+	return Authorize(func(r *http.Request) bool {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || token == "" {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+	})
+}
+
+// Authorize is a functional argument you can pass to Handler() to decide which PATCH requests
+// are accepted
+func Authorize(authorize func(r *http.Request) bool) func(*handlerOptions) {
+	return func(o *handlerOptions) {
+		o.authorize = authorize
+	}
+}
+
+// Handler returns an http.Handler serving the configuration of the default Loader:
+//   - GET /config: the current Configuration as JSON, with secrets masked
+//   - GET /config/sources: the provenance of every field, see Sources
+//   - PATCH /config: applies a JSON merge patch (RFC 7396) to the current Configuration, then
+//     validates it and makes it current. Keys that do not match a field are rejected. The
+//     patch lasts until the next Init or Reload.
+//
+// PATCH requires the BearerToken or Authorize option and is refused without it. Mount the
+// handler on both paths of an existing mux:
+//
+//	h := config.Handler(config.BearerToken(os.Getenv("ADMIN_TOKEN")))
+//	mux.Handle("/config", h)
+//	mux.Handle("/config/sources", h)
+func Handler(options ...func(*handlerOptions)) http.Handler {
+	return defaultLoader.Handler(options...)
+}
+
+// Handler returns an http.Handler serving the configuration of the Loader. See the package
+// level Handler.
+func (l *Loader) Handler(options ...func(*handlerOptions)) http.Handler {
+	h := &configHandler{loader: l}
+	for _, option := range options {
+		option(&h.options)
+	}
+	return h
+}
+
+type configHandler struct {
+	loader  *Loader
+	options handlerOptions
+}
+
+func (h *configHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch path := strings.TrimSuffix(r.URL.Path, "/"); {
+	case path == "/config" && r.Method == http.MethodGet:
+		h.getConfig(w)
+	case path == "/config" && r.Method == http.MethodPatch:
+		h.patchConfig(w, r)
+	case path == "/config":
+		w.Header().Set("Allow", "GET, PATCH")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	case path == "/config/sources" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, h.loader.Sources())
+	case path == "/config/sources":
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *configHandler) getConfig(w http.ResponseWriter) {
+	b, err := maskedJSON(h.loader.Config())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+func (h *configHandler) patchConfig(w http.ResponseWriter, r *http.Request) {
+	if h.options.authorize == nil {
+		writeError(w, http.StatusForbidden, fmt.Errorf("patching the configuration is disabled"))
+		return
+	}
+	if !h.options.authorize(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+		return
+	}
+	contentType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+	if contentType != "application/merge-patch+json" {
+		writeError(w, http.StatusUnsupportedMediaType,
+			fmt.Errorf("content type must be application/merge-patch+json"))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPatchSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxPatchSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("patch larger than %d bytes", maxPatchSize))
+		return
+	}
+
+	conf, err := h.loader.Patch(body)
+	if err != nil {
+		status := http.StatusBadRequest
+		var verrs ValidationErrors
+		if errors.As(err, &verrs) {
+			status = http.StatusUnprocessableEntity
+		}
+		writeError(w, status, err)
+		return
+	}
+	log.Infof("Configuration patched by %s", r.RemoteAddr)
+	b, err := maskedJSON(conf)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// Patch applies a JSON merge patch (RFC 7396) to a copy of the current Configuration of the
+// Loader, validates it and makes it current: objects are merged field by field, including the
+// entries of maps, null resets a field to its zero value or deletes a map entry, and other
+// values replace the field as encoding/json decodes them. Keys that do not match a field are
+// rejected. The OnChange listeners are notified and the provenance of the patched fields is
+// updated.
+func (l *Loader) Patch(patch []byte) (*Configuration, error) {
+	d := json.NewDecoder(bytes.NewReader(patch))
+	d.UseNumber()
+	var tree interface{}
+	if err := d.Decode(&tree); err != nil {
+		return nil, jsonError("patch", patch, err)
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("patch: unexpected data after the JSON document")
+	}
+	obj, ok := tree.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("patch must be a JSON object")
+	}
+
+	l.swapLock.Lock()
+	old := l.Config()
+	conf := *old
+	deepCopy(reflect.ValueOf(&conf).Elem())
+	if err := checkUnknownKeys("patch", FormatJSON, patch, obj, &conf, true); err != nil {
+		l.swapLock.Unlock()
+		return nil, err
+	}
+	if err := mergePatch(reflect.ValueOf(&conf).Elem(), obj, ""); err != nil {
+		l.swapLock.Unlock()
+		return nil, fmt.Errorf("patch: %w", err)
+	}
+	if err := Validate(&conf); err != nil {
+		l.swapLock.Unlock()
+		return nil, err
+	}
+	level := logLevel(&conf)
+	l.current.Store(&conf)
+	l.patchProvenance(obj, &conf)
+	l.swapLock.Unlock()
+
+	l.setLogging(&conf, level)
+	if !reflect.DeepEqual(old, &conf) {
+		l.notify(old, &conf)
+	}
+	return &conf, nil
+}
+
+// patchProvenance records the values of a patch as the source of the fields it sets. Fields
+// reset with null, and the fields below them, no longer have a source. Keys are matched with
+// the json names of fields ignoring case, as mergePatch does.
+func (l *Loader) patchProvenance(patch map[string]interface{}, conf *Configuration) {
+	secrets := make(map[string]bool)
+	secretPaths(reflect.TypeOf(conf).Elem(), "", 4, secrets)
+	keys := make(map[string]string)
+	(&walker{}).walkStruct(reflect.ValueOf(conf).Elem(), "", 4,
+		func(_ reflect.Value, _ reflect.StructField, path string, key string) {
+			keys[path] = key
+		})
+
+	l.provenanceLock.Lock()
+	defer l.provenanceLock.Unlock()
+	table := make(map[string]*Provenance, len(l.provenance))
+	for path, p := range l.provenance {
+		table[path] = p
+	}
+	var record func(t reflect.Type, path string, value interface{})
+	record = func(t reflect.Type, path string, value interface{}) {
+		switch val := value.(type) {
+		case map[string]interface{}:
+			var fields map[string][]int
+			var elem reflect.Type
+			if t != nil {
+				switch t = indirectType(t); t.Kind() {
+				case reflect.Struct:
+					fields = jsonFields(t)
+				case reflect.Map:
+					elem = t.Elem()
+				}
+			}
+			for key, child := range val {
+				if index, ok := fields[strings.ToLower(key)]; ok {
+					childPath, ft := matchedField(t, path, index)
+					record(ft, childPath, child)
+				} else {
+					record(elem, joinPath(path, key), child)
+				}
+			}
+			return
+		case nil:
+			for p := range table {
+				if p == path || strings.HasPrefix(p, path+".") {
+					delete(table, p)
+				}
+			}
+			return
+		}
+		values := make(map[string]string)
+		flattenValue(path, value, values)
+		p := &Provenance{Path: path, Key: keys[path], Source: Source{Name: patchSource, Value: values[path]}}
+		if previous, ok := table[path]; ok {
+			p.Shadowed = append([]Source{previous.Source}, previous.Shadowed...)
+		}
+		if secrets[path] {
+			p.mask()
+		}
+		table[path] = p
+	}
+	record(reflect.TypeOf(conf), "", patch)
+	l.provenance = table
+}
+
+// deepCopy replaces the pointers, slices and maps reachable from the exported fields of v,
+// which must be settable, with copies, so that v shares no data with the value it was copied from
+func deepCopy(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+		p := reflect.New(v.Type().Elem())
+		p.Elem().Set(v.Elem())
+		deepCopy(p.Elem())
+		v.Set(p)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			deepCopy(v.Field(i))
+		}
+	case reflect.Slice:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(s, v)
+		for i := 0; i < s.Len(); i++ {
+			deepCopy(s.Index(i))
+		}
+		v.Set(s)
+	case reflect.Map:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			deepCopy(elem)
+			m.SetMapIndex(iter.Key(), elem)
+		}
+		v.Set(m)
+	}
+}
+
+// mergePatch applies the object of a JSON merge patch to v, a struct or a map: objects are
+// merged into structs and into the existing entries of maps, null resets a field or deletes a
+// map entry and other values are decoded by encoding/json. Keys are matched with the json names
+// of fields ignoring case, as encoding/json does. path is the dotted path of v, for errors.
+func mergePatch(v reflect.Value, patch map[string]interface{}, path string) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	switch {
+	case isLeaf(v.Type()) || isJSONLeaf(v.Type()):
+	case v.Kind() == reflect.Struct:
+		fields := jsonFields(v.Type())
+		for key, value := range patch {
+			index, ok := fields[strings.ToLower(key)]
+			if !ok {
+				// rejected by checkUnknownKeys
+				continue
+			}
+			if err := mergeValue(v.FieldByIndex(index), value, joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case v.Kind() == reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for key, value := range patch {
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := setFieldValue(k, key, ","); err != nil {
+				return fmt.Errorf("%s: invalid key %q: %v", path, key, err)
+			}
+			if value == nil {
+				v.SetMapIndex(k, reflect.Value{})
+				continue
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if existing := v.MapIndex(k); existing.IsValid() {
+				elem.Set(existing)
+			}
+			if err := mergeValue(elem, value, joinPath(path, key)); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, elem)
+		}
+		return nil
+	}
+	return decodePatchValue(v, patch, path)
+}
+
+// mergeValue applies the value of a key of a merge patch to v, which must be addressable
+func mergeValue(v reflect.Value, value interface{}, path string) error {
+	switch val := value.(type) {
+	case nil:
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	case map[string]interface{}:
+		return mergePatch(v, val, path)
+	}
+	return decodePatchValue(v, value, path)
+}
+
+// decodePatchValue replaces v, which must be addressable, with a value of a merge patch
+func decodePatchValue(v reflect.Value, value interface{}, path string) error {
+	b, err := json.Marshal(value)
+	if err == nil {
+		err = json.Unmarshal(b, v.Addr().Interface())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mergePatchType = "application/merge-patch+json"
+
+// newTestHandler returns a handler serving a Loader initialized with a commit, and the Loader
+func newTestHandler(t *testing.T, options ...func(*handlerOptions)) (http.Handler, *Loader) {
+	t.Helper()
+	l := NewLoader(ConfigFiles())
+	if _, err := l.Init(Defaults(Set(COMMIT, "abc123"))); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	return l.Handler(options...), l
+}
+
+func serve(h http.Handler, method string, path string, contentType string, body string,
+	header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandlerGet(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	w := serve(h, http.MethodGet, "/config", "", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /config = %d %s, want 200 application/json", w.Code, w.Header().Get("Content-Type"))
+	}
+	var conf Configuration
+	if err := json.Unmarshal(w.Body.Bytes(), &conf); err != nil {
+		t.Fatalf("GET /config body %s: %v", w.Body, err)
+	}
+	if conf.Build.Commit != "abc123" || conf.LogLevel != "INFO" {
+		t.Errorf("GET /config = %+v, want the current configuration", conf)
+	}
+
+	w = serve(h, http.MethodGet, "/config/sources", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /config/sources = %d, want 200", w.Code)
+	}
+	var sources []Provenance
+	if err := json.Unmarshal(w.Body.Bytes(), &sources); err != nil {
+		t.Fatalf("GET /config/sources body %s: %v", w.Body, err)
+	}
+	found := false
+	for _, p := range sources {
+		if p.Path == "build.commit" {
+			found = p.Key == COMMIT && p.Source.Value == "abc123"
+		}
+	}
+	if !found {
+		t.Errorf("GET /config/sources = %+v, want build.commit from the defaults", sources)
+	}
+
+	if w := serve(h, http.MethodPost, "/config", "", ""); w.Code != http.StatusMethodNotAllowed ||
+		w.Header().Get("Allow") != "GET, PATCH" {
+		t.Errorf("POST /config = %d, Allow %q, want 405 with GET, PATCH", w.Code, w.Header().Get("Allow"))
+	}
+	if w := serve(h, http.MethodGet, "/other", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET /other = %d, want 404", w.Code)
+	}
+}
+
+func TestHandlerPatchErrors(t *testing.T) {
+	const token = "s3cret"
+	tests := []struct {
+		name        string
+		options     []func(*handlerOptions)
+		contentType string
+		auth        string
+		body        string
+		want        int
+	}{
+		{"disabled", nil, mergePatchType, "Bearer " + token, `{"log_level":"debug"}`, http.StatusForbidden},
+		{"no token", []func(*handlerOptions){BearerToken(token)}, mergePatchType, "", `{"log_level":"debug"}`,
+			http.StatusUnauthorized},
+		{"wrong token", []func(*handlerOptions){BearerToken(token)}, mergePatchType, "Bearer nope",
+			`{"log_level":"debug"}`, http.StatusUnauthorized},
+		{"content type", []func(*handlerOptions){BearerToken(token)}, "application/json", "Bearer " + token,
+			`{"log_level":"debug"}`, http.StatusUnsupportedMediaType},
+		{"malformed", []func(*handlerOptions){BearerToken(token)}, mergePatchType, "Bearer " + token,
+			`{"log_level":`, http.StatusBadRequest},
+		{"not an object", []func(*handlerOptions){BearerToken(token)}, mergePatchType, "Bearer " + token,
+			`["debug"]`, http.StatusBadRequest},
+		{"unknown key", []func(*handlerOptions){BearerToken(token)}, mergePatchType, "Bearer " + token,
+			`{"build":{"vesion":"2.0"}}`, http.StatusBadRequest},
+		{"wrong type", []func(*handlerOptions){BearerToken(token)}, mergePatchType, "Bearer " + token,
+			`{"log_level":3}`, http.StatusBadRequest},
+		{"invalid value", []func(*handlerOptions){BearerToken(token)}, mergePatchType, "Bearer " + token,
+			`{"log_level":"verbose","log_format":"xml"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, l := newTestHandler(t, tt.options...)
+			before := l.Config()
+			w := serve(h, http.MethodPatch, "/config", tt.contentType, tt.body, "Authorization", tt.auth)
+			if w.Code != tt.want {
+				t.Fatalf("PATCH /config = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["error"] == "" {
+				t.Errorf("PATCH /config body = %s, want an error message", w.Body)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", w.Header().Get("WWW-Authenticate"))
+			}
+			if l.Config() != before {
+				t.Errorf("configuration changed by a failed PATCH")
+			}
+		})
+	}
+}
+
+func TestHandlerPatch(t *testing.T) {
+	h, l := newTestHandler(t, Authorize(func(r *http.Request) bool { return true }))
+	var changes []*Configuration
+	l.OnChange(func(old, new *Configuration) {
+		changes = append(changes, new)
+	})
+
+	w := serve(h, http.MethodPatch, "/config", mergePatchType+"; charset=utf-8",
+		`{"log_level":"debug","build":{"branch":"main"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH /config = %d %s, want 200", w.Code, w.Body)
+	}
+	conf := l.Config()
+	if conf.LogLevel != "debug" || conf.Build.Branch != "main" || conf.Build.Commit != "abc123" {
+		t.Errorf("patched configuration = %+v, want the patched fields and the others kept", conf)
+	}
+	if len(changes) != 1 || changes[0] != conf {
+		t.Errorf("OnChange called %d times, want once with the new configuration", len(changes))
+	}
+	if p, ok := l.Explain("build.branch"); !ok || p.Source != (Source{Name: patchSource, Value: "main"}) {
+		t.Errorf("Explain(build.branch) = %v, want the patch as source", p)
+	}
+
+	w = serve(h, http.MethodPatch, "/config", mergePatchType, `{"build":{"commit":null}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH /config = %d %s, want 200", w.Code, w.Body)
+	}
+	if conf := l.Config(); conf.Build.Commit != "" || conf.Build.Branch != "main" {
+		t.Errorf("configuration = %+v, want the commit reset by null and the branch kept", conf.Build)
+	}
+	if p, ok := l.Explain("build.commit"); ok {
+		t.Errorf("Explain(build.commit) = %v, want no source for a field reset by null", p)
+	}
+}
+
+type patchTarget struct {
+	Name     string                    `json:"name"`
+	Servers  map[string]patchServer    `json:"servers"`
+	Pointers map[string]*patchServer   `json:"pointers"`
+	Nested   map[string]map[string]int `json:"nested"`
+	Tags     []string                  `json:"tags"`
+}
+
+type patchServer struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+func TestMergePatch(t *testing.T) {
+	conf := patchTarget{
+		Name:     "app",
+		Servers:  map[string]patchServer{"a": {"a.local", 80}, "b": {"b.local", 81}},
+		Pointers: map[string]*patchServer{"a": {"a.local", 80}},
+		Nested:   map[string]map[string]int{"x": {"one": 1, "two": 2}},
+		Tags:     []string{"a", "b"},
+	}
+	patch := map[string]interface{}{
+		"NAME": nil,
+		"servers": map[string]interface{}{
+			"a": map[string]interface{}{"port": json.Number("8080")},
+			"b": nil,
+			"c": map[string]interface{}{"host": "c.local"},
+		},
+		"pointers": map[string]interface{}{"a": map[string]interface{}{"host": "new.local"}},
+		"nested":   map[string]interface{}{"x": map[string]interface{}{"two": nil, "three": json.Number("3")}},
+		"tags":     []interface{}{"c"},
+	}
+	if err := mergePatch(reflect.ValueOf(&conf).Elem(), patch, ""); err != nil {
+		t.Fatalf("mergePatch() error = %v", err)
+	}
+	want := patchTarget{
+		Servers:  map[string]patchServer{"a": {"a.local", 8080}, "c": {"c.local", 0}},
+		Pointers: map[string]*patchServer{"a": {"new.local", 80}},
+		Nested:   map[string]map[string]int{"x": {"one": 1, "three": 3}},
+		Tags:     []string{"c"},
+	}
+	if !reflect.DeepEqual(conf, want) {
+		t.Errorf("mergePatch() = %+v, want %+v", conf, want)
+	}
+
+	err := mergePatch(reflect.ValueOf(&conf).Elem(),
+		map[string]interface{}{"servers": map[string]interface{}{"a": map[string]interface{}{"port": "x"}}}, "")
+	if err == nil || !strings.HasPrefix(err.Error(), "servers.a.port: ") {
+		t.Errorf("mergePatch() error = %v, want the path of the invalid value", err)
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseINI parses an INI file. Keys before the first [section] belong to the root object,
+// dotted section names such as [build.info] create nested objects, and both = and : separate
+// keys from values. Lines starting with ; or # are comments.
+func parseINI(data []byte) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	section := root
+	for i, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("ini: line %d: unterminated section header", i+1)
+			}
+			section = root
+			for _, name := range strings.Split(line[1:len(line)-1], ".") {
+				name = strings.TrimSpace(name)
+				child, ok := section[name].(map[string]interface{})
+				if !ok {
+					child = map[string]interface{}{}
+					section[name] = child
+				}
+				section = child
+			}
+			continue
+		}
+		sep := strings.IndexAny(line, "=:")
+		if sep < 0 {
+			return nil, fmt.Errorf("ini: line %d: expected key = value", i+1)
+		}
+		key := strings.TrimSpace(line[:sep])
+		value, err := unquoteValue(strings.TrimSpace(line[sep+1:]), "#;")
+		if err != nil {
+			return nil, fmt.Errorf("ini: line %d: %v", i+1, err)
+		}
+		section[key] = value
+	}
+	return root, nil
+}
+
+// unquoteValue removes the quotes around a double or single quoted value. Double quoted
+// values may contain escape sequences. Unquoted values lose any trailing comment, which starts
+// with one of the comment characters preceded by a blank.
+func unquoteValue(value string, comments string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	switch value[0] {
+	case '"':
+		end := closingQuote(value, 0)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", value)
+		}
+		return strconv.Unquote(value[:end+1])
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", value)
+		}
+		return value[1 : end+1], nil
+	}
+	for i := 1; i < len(value); i++ {
+		if strings.IndexByte(comments, value[i]) >= 0 && (value[i-1] == ' ' || value[i-1] == '\t') {
+			value = value[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// closingQuote returns the index of the double quote that closes the string starting at start,
+// skipping escaped characters, or -1
+func closingQuote(text string, start int) int {
+	for i := start + 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
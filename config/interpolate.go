@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// InterpolationError describes a ${KEY} reference that could not be expanded
+type InterpolationError struct {
+	// Reference is the reference that failed, e.g. ${DB_HOST:?is required}
+	Reference string
+	// Cycle lists the keys of a reference cycle, the first key is repeated at the end
+	Cycle []string
+	// Message explains the failure
+	Message string
+}
+
+func (e *InterpolationError) Error() string {
+	if len(e.Cycle) > 0 {
+		return "reference cycle " + strings.Join(e.Cycle, " -> ")
+	}
+	return fmt.Sprintf("%s: %s", e.Reference, e.Message)
+}
+
+// fieldRef is a field that can be referenced by its key
+type fieldRef struct {
+	path string
+	ft   reflect.StructField
+}
+
+// collectField records a field so that other values can reference it
+func (w *walker) collectField(_ reflect.Value, ft reflect.StructField, path string, key string) {
+	if _, ok := w.fields[key]; !ok {
+		w.fields[key] = fieldRef{path: path, ft: ft}
+	}
+}
+
+// expand replaces the ${KEY} references of value, in the manner of the shell:
+//   - ${KEY} is the value of KEY, or "" if it has none
+//   - ${KEY:-default} is default if KEY has no value or an empty one
+//   - ${KEY:?message} fails with message if KEY has no value or an empty one
+//
+// $${ is a literal ${. stack holds the keys being expanded, to detect cycles.
+func (w *walker) expand(value string, stack []string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if strings.HasPrefix(value[i:], "$${") {
+			b.WriteString("${")
+			i += 2
+			continue
+		}
+		if !strings.HasPrefix(value[i:], "${") {
+			b.WriteByte(value[i])
+			continue
+		}
+		end := closingBrace(value, i+2)
+		if end < 0 {
+			return "", &InterpolationError{Reference: value[i:], Message: "missing closing brace"}
+		}
+		expanded, err := w.expandReference(value[i:end+1], stack)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(expanded)
+		i = end
+	}
+	return b.String(), nil
+}
+
+// expandReference expands a single ${...} reference
+func (w *walker) expandReference(ref string, stack []string) (string, error) {
+	expr := ref[2 : len(ref)-1]
+	name, op, arg := expr, "", ""
+	if i := strings.Index(expr, ":"); i >= 0 && i+1 < len(expr) && (expr[i+1] == '-' || expr[i+1] == '?') {
+		name, op, arg = expr[:i], expr[i:i+2], expr[i+2:]
+	}
+	if !isKeyName(name) {
+		return "", &InterpolationError{Reference: ref, Message: "invalid key name"}
+	}
+	for i, key := range stack {
+		if key == name {
+			return "", &InterpolationError{Reference: ref, Cycle: append(stack[i:len(stack):len(stack)], name)}
+		}
+	}
+
+	value, err := w.resolveKey(name, append(stack[:len(stack):len(stack)], name))
+	if err != nil || value != "" {
+		return value, err
+	}
+	switch op {
+	case ":-":
+		return w.expand(arg, stack)
+	case ":?":
+		if arg == "" {
+			arg = "is required"
+		}
+		msg, err := w.expand(arg, stack)
+		if err != nil {
+			return "", err
+		}
+		return "", &InterpolationError{Reference: ref, Message: name + " " + msg}
+	}
+	return "", nil
+}
+
+// resolveKey returns the expanded and decrypted value of key: the value of the providers, or of
+// the field with that key from the config files or its default tag
+func (w *walker) resolveKey(key string, stack []string) (string, error) {
+	values, provErrs := w.getValues(key)
+	if len(provErrs) > 0 {
+		return "", fmt.Errorf("provider %s failed: %w", provErrs[0].provider, provErrs[0].err)
+	}
+	if field, ok := w.fields[key]; ok {
+		values = append(values, w.fileValues[field.path]...)
+		if defaultTag := field.ft.Tag.Get("default"); defaultTag != "" {
+			values = append(values, Source{Name: defaultTagSource, Value: defaultTag})
+		}
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+	value, err := w.expand(values[0].Value, stack)
+	if err != nil {
+		return "", err
+	}
+	return Decrypt(value, w.key)
+}
+
+// closingBrace returns the index of the brace that closes the reference whose content starts
+// at start, allowing nested references in default values, or -1
+func closingBrace(value string, start int) int {
+	depth := 0
+	for i := start; i < len(value); i++ {
+		switch {
+		case strings.HasPrefix(value[i:], "${"):
+			depth++
+			i++
+		case value[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// isKeyName reports whether name is an environment variable style key
+func isKeyName(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
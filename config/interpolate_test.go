@@ -0,0 +1,102 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type interpolationConfig struct {
+	Host string `json:"host" env:"ITEST_HOST" default:"localhost"`
+	Port string `json:"port" env:"ITEST_PORT"`
+	URL  string `json:"url" env:"ITEST_URL"`
+}
+
+func TestInterpolation(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults map[string]string
+		want     string
+		// wantErr is the expected text of the error, if any
+		wantErr string
+	}{
+		{"reference", map[string]string{"ITEST_URL": "${ITEST_HOST}"}, "localhost", ""},
+		{"references in text", map[string]string{"ITEST_URL": "db://${ITEST_HOST}:${ITEST_PORT}/app",
+			"ITEST_PORT": "5432"}, "db://localhost:5432/app", ""},
+		{"reference to a reference", map[string]string{"ITEST_URL": "db://${ITEST_PORT}",
+			"ITEST_PORT": "${ITEST_HOST}:5432"}, "db://localhost:5432", ""},
+		{"unknown key", map[string]string{"ITEST_URL": "[${ITEST_NOT_SET}]"}, "[]", ""},
+		{"default", map[string]string{"ITEST_URL": "${ITEST_PORT:-5432}"}, "5432", ""},
+		{"default of an empty value", map[string]string{"ITEST_URL": "${ITEST_PORT:-5432}", "ITEST_PORT": ""},
+			"5432", ""},
+		{"default not used", map[string]string{"ITEST_URL": "${ITEST_PORT:-5432}", "ITEST_PORT": "6543"},
+			"6543", ""},
+		{"nested default", map[string]string{"ITEST_URL": "${ITEST_PORT:-${ITEST_HOST}}"}, "localhost", ""},
+		{"escaped", map[string]string{"ITEST_URL": "$${ITEST_HOST} is ${ITEST_HOST}"},
+			"${ITEST_HOST} is localhost", ""},
+		{"dollar", map[string]string{"ITEST_URL": "$5 {x} $ITEST_HOST"}, "$5 {x} $ITEST_HOST", ""},
+		{"required", map[string]string{"ITEST_URL": "${ITEST_PORT:?must be set}"}, "",
+			`url (ITEST_URL): cannot expand "${ITEST_PORT:?must be set}" from map: ` +
+				"${ITEST_PORT:?must be set}: ITEST_PORT must be set"},
+		{"required without message", map[string]string{"ITEST_URL": "${ITEST_PORT:?}"}, "",
+			"${ITEST_PORT:?}: ITEST_PORT is required"},
+		{"required and set", map[string]string{"ITEST_URL": "${ITEST_PORT:?must be set}", "ITEST_PORT": "1"},
+			"1", ""},
+		{"invalid key", map[string]string{"ITEST_URL": "${ITEST-PORT}"}, "", "${ITEST-PORT}: invalid key name"},
+		{"missing brace", map[string]string{"ITEST_URL": "x${ITEST_HOST"}, "",
+			"${ITEST_HOST: missing closing brace"},
+		{"cycle", map[string]string{"ITEST_URL": "${ITEST_PORT}", "ITEST_PORT": "${ITEST_URL}"}, "",
+			`port (ITEST_PORT): cannot expand "${ITEST_URL}" from map: ` +
+				"reference cycle ITEST_PORT -> ITEST_URL -> ITEST_PORT"},
+		{"self reference", map[string]string{"ITEST_URL": "a${ITEST_URL}"}, "",
+			"reference cycle ITEST_URL -> ITEST_URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var setters []func(*initOptions)
+			for key, value := range tt.defaults {
+				setters = append(setters, Set(key, value))
+			}
+			var conf interpolationConfig
+			err := NewLoader(ConfigFiles()).Load(&conf, Defaults(setters...))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load() error = %v", err)
+				}
+				if conf.URL != tt.want {
+					t.Errorf("url = %q, want %q", conf.URL, tt.want)
+				}
+				return
+			}
+			if interpolationError(err) == nil {
+				t.Fatalf("Load() error = %v, want an InterpolationError", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInterpolationCycleError(t *testing.T) {
+	var conf interpolationConfig
+	err := NewLoader(ConfigFiles()).Load(&conf,
+		Defaults(Set("ITEST_URL", "${ITEST_PORT}"), Set("ITEST_PORT", "${ITEST_URL}")))
+	ie := interpolationError(err)
+	if ie == nil {
+		t.Fatalf("Load() error = %v, want an InterpolationError", err)
+	}
+	if got := strings.Join(ie.Cycle, " "); got != "ITEST_PORT ITEST_URL ITEST_PORT" {
+		t.Errorf("Cycle = %s, want ITEST_PORT ITEST_URL ITEST_PORT", got)
+	}
+}
+
+// interpolationError returns the InterpolationError of the first FieldError of err, or nil
+func interpolationError(err error) *InterpolationError {
+	var errs FieldErrors
+	var ie *InterpolationError
+	if !errors.As(err, &errs) || !errors.As(errs[0], &ie) {
+		return nil
+	}
+	return ie
+}
@@ -0,0 +1,388 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"grail/sysinfra/cfg/log"
+)
+
+// Loader loads configuration data from config files, default values and data providers. Each
+// Loader owns its provider chain, default values, config file options, base and resulting
+// Configuration, so several of them can be used at the same time, for example in parallel
+// tests. All methods are safe for concurrent use. The package level functions such as Init,
+// Config and AddDataProvider use a default Loader, which is the only one that sets the level
+// and format of the log package.
+type Loader struct {
+	// options apply to every load, before the options of the call
+	options  []func(*initOptions)
+	defaults *MapProvider
+
+	// base is the configuration Init starts from, updated by InitFromConfigFiles
+	baseLock sync.RWMutex
+	base     Configuration
+
+	providersLock sync.RWMutex
+	providers     []KeyValueProvider
+
+	provenanceLock sync.RWMutex
+	// provenance is the provenance of the current Configuration
+	provenance map[string]*Provenance
+	// valueFiles lists the files named by _FILE keys in the last load of the Configuration
+	valueFiles []string
+	// structProvenance holds the provenance of the last structure of each type filled by Load
+	// or ApplyExternalConfig
+	structProvenance map[reflect.Type]map[string]*Provenance
+
+	// current holds the current *Configuration. A reload replaces it as a whole, so callers
+	// holding a previous value keep a consistent snapshot.
+	current atomic.Value
+
+	// reloadLock protects the options used by Reload and the state of the watcher
+	reloadLock    sync.Mutex
+	reloadOptions []func(*initOptions)
+	stopWatch     chan struct{}
+	// swapLock makes loads of the Configuration run one at a time
+	swapLock sync.Mutex
+
+	listenersLock sync.Mutex
+	listeners     []func(old, new *Configuration)
+}
+
+// NewLoader creates a Loader that reads environment variables and its own map of default
+// values. The options, for example ConfigFiles, apply to every load of the Loader, before
+// the options passed to Init or Load.
+func NewLoader(options ...func(*initOptions)) *Loader {
+	return newLoader(&MapProvider{}, options)
+}
+
+func newLoader(defaults *MapProvider, options []func(*initOptions)) *Loader {
+	return &Loader{
+		options:   options,
+		defaults:  defaults,
+		base:      defaultConfiguration,
+		providers: []KeyValueProvider{&EnvironmentProvider{}, defaults},
+	}
+}
+
+// defaultLoader is used by the package level functions
+var defaultLoader = newLoader(DefaultMapProvider, nil)
+
+// Defaults returns the map provider holding the default values of the Loader, which apply to
+// every load. The values of the Defaults option only apply to the call they are passed to.
+func (l *Loader) Defaults() *MapProvider {
+	return l.defaults
+}
+
+// ClearDataProviders removes all configuration data providers
+func (l *Loader) ClearDataProviders() {
+	l.providersLock.Lock()
+	defer l.providersLock.Unlock()
+	l.providers = nil
+}
+
+// AddDataProvider adds a new KeyValueProvider to the list of providers
+// that are checked for configuration values. The list is in descending order
+// of priority.
+func (l *Loader) AddDataProvider(p KeyValueProvider) {
+	l.providersLock.Lock()
+	defer l.providersLock.Unlock()
+	l.providers = append(l.providers, p)
+}
+
+// PrependDataProvider adds a new KeyValueProvider with a higher priority than all the
+// providers already in the list.
+func (l *Loader) PrependDataProvider(p KeyValueProvider) {
+	l.providersLock.Lock()
+	defer l.providersLock.Unlock()
+	l.providers = append([]KeyValueProvider{p}, l.providers...)
+}
+
+// dataProviders returns a copy of the provider chain
+func (l *Loader) dataProviders() []KeyValueProvider {
+	l.providersLock.RLock()
+	defer l.providersLock.RUnlock()
+	return append([]KeyValueProvider(nil), l.providers...)
+}
+
+// ApplyExternalConfig walks through the specified configuration data structure and
+// updates the configuration fields from the data providers of the Loader. See the
+// package level ApplyExternalConfig.
+func (l *Loader) ApplyExternalConfig(s interface{}, maxDepth int) error {
+	table, _, err := l.applyExternalConfig(s, maxDepth, nil, &initOptions{})
+	if table != nil {
+		l.setStructProvenance(s, table)
+	}
+	return err
+}
+
+// applyExternalConfig is ApplyExternalConfig for a structure that already holds the values of
+// the config files listed in fileValues, using the Defaults, EnvPrefix, AutoEnv and
+// EncryptionKey options. It returns the provenance of the values and the files named by _FILE
+// keys.
+func (l *Loader) applyExternalConfig(s interface{}, maxDepth int, fileValues map[string][]Source,
+	ops *initOptions) (map[string]*Provenance, []string, error) {
+	key, err := encryptionKey(ops)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := walker{providers: l.callProviders(ops), fileValues: fileValues, table: make(map[string]*Provenance),
+		envPrefix: ops.EnvPrefix, autoEnv: ops.AutoEnv, key: key, fields: make(map[string]fieldRef),
+		now: ops.clock()}
+	for path, sources := range fileValues {
+		w.table[path] = &Provenance{Path: path, Source: sources[0], Shadowed: sources[1:]}
+	}
+	w.walkStruct(reflect.ValueOf(s).Elem(), "", maxDepth, w.collectField)
+	w.walkStruct(reflect.ValueOf(s).Elem(), "", maxDepth, w.walkField)
+	secrets := make(map[string]bool)
+	secretPaths(reflect.TypeOf(s).Elem(), "", maxDepth, secrets)
+	for path := range secrets {
+		if p, ok := w.table[path]; ok {
+			p.mask()
+		}
+	}
+	if len(w.errs) > 0 {
+		return w.table, w.files, w.errs
+	}
+	return w.table, w.files, nil
+}
+
+// callProviders returns the provider chain of a load. The values of the Defaults option are
+// served by a map provider of their own, just before the map provider of the Loader, so that
+// they only apply to this load.
+func (l *Loader) callProviders(ops *initOptions) []KeyValueProvider {
+	providers := l.dataProviders()
+	if len(ops.DefaultValues) == 0 {
+		return providers
+	}
+	defaults := &MapProvider{}
+	for key, value := range ops.DefaultValues {
+		defaults.Set(key, value)
+	}
+	for i, p := range providers {
+		if p == KeyValueProvider(l.defaults) {
+			return append(providers[:i:i], append([]KeyValueProvider{defaults}, providers[i:]...)...)
+		}
+	}
+	return append(providers, defaults)
+}
+
+// Init loads a new Configuration and makes it the current configuration of the Loader. The
+// default Loader also sets the log level and format. See the package level Init.
+func (l *Loader) Init(options ...func(*initOptions)) (*Configuration, error) {
+	snapshot := l.snapshotFiles(options)
+	l.swapLock.Lock()
+	conf, level, err := l.newConfiguration(options)
+	if err != nil {
+		l.swapLock.Unlock()
+		return nil, err
+	}
+	l.current.Store(conf)
+	l.swapLock.Unlock()
+
+	l.setLogging(conf, level)
+	b, err := maskedJSON(conf)
+	if err == nil {
+		log.Infof("Configuration: %s", string(b))
+	}
+
+	l.startWatching(options, snapshot)
+	return conf, nil
+}
+
+// setLogging applies the log level and format of a new current configuration of the default
+// Loader. Other Loaders leave the log package alone, so that they do not affect each other.
+func (l *Loader) setLogging(conf *Configuration, level log.Level) {
+	if l != defaultLoader {
+		return
+	}
+	log.SetLevel(level)
+	if err := log.SetFormat(conf.LogFormat); err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+// Config returns the current configuration of the Loader
+func (l *Loader) Config() *Configuration {
+	if conf, ok := l.current.Load().(*Configuration); ok {
+		return conf
+	}
+	return &Configuration{}
+}
+
+// Load fills conf, which must be a pointer to a struct, using the same pipeline as Init. See
+// the package level Load.
+func (l *Loader) Load(conf interface{}, options ...func(*initOptions)) error {
+	table, _, err := l.load(conf, options)
+	if table != nil {
+		l.setStructProvenance(conf, table)
+	}
+	return err
+}
+
+// newConfiguration loads a new Configuration and returns it with the log level it specifies.
+// The provenance of the Loader is replaced by the provenance of the new Configuration.
+func (l *Loader) newConfiguration(options []func(*initOptions)) (*Configuration, log.Level, error) {
+	l.baseLock.RLock()
+	conf := l.base
+	l.baseLock.RUnlock()
+	table, valueFiles, err := l.load(&conf, options)
+	if err != nil {
+		return nil, log.INFO, err
+	}
+	l.setProvenance(table, valueFiles)
+	return &conf, logLevel(&conf), nil
+}
+
+// logLevel returns the level named by the LogLevel of a validated conf
+func logLevel(conf *Configuration) log.Level {
+	var level log.Level
+	_ = level.UnmarshalText([]byte(conf.LogLevel))
+	return level
+}
+
+// load fills conf, which must be a pointer to a struct, from the config file, the default values
+// and the data providers, then validates it. It returns the provenance of the values, once the
+// providers are applied, and the files named by _FILE keys.
+func (l *Loader) load(conf interface{}, options []func(*initOptions)) (map[string]*Provenance, []string, error) {
+	if t := reflect.TypeOf(conf); t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("configuration must be a pointer to a struct, got %T", conf)
+	}
+
+	ops := l.initOptions(options)
+	key, err := encryptionKey(&ops)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading the decryption key: %w", err)
+	}
+	ops.EncryptionKey = key
+	fileValues, err := initFromConfigFileStack(configFiles(&ops), &ops, conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading config files: %w", err)
+	}
+
+	// apply data from the default values and external data providers
+	table, valueFiles, err := l.applyExternalConfig(conf, 4, fileValues, &ops)
+	if err != nil {
+		return table, valueFiles, fmt.Errorf("error resolving config values: %w", err)
+	}
+
+	err = Validate(conf)
+	if err != nil {
+		return table, valueFiles, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return table, valueFiles, nil
+}
+
+// InitFromConfigFiles reads the config files of the Loader into its base configuration, which
+// Init starts from
+func (l *Loader) InitFromConfigFiles() {
+	ops := l.initOptions(nil)
+	key, err := encryptionKey(&ops)
+	if err != nil {
+		log.Errorf("cannot read the decryption key: %v", err)
+	}
+	ops.EncryptionKey = key
+	ops.Strict = false
+	l.baseLock.Lock()
+	defer l.baseLock.Unlock()
+	_, _ = initFromConfigFileStack(configFiles(&ops), &ops, &l.base)
+}
+
+// initOptions applies the options of the Loader followed by the specified options
+func (l *Loader) initOptions(options []func(*initOptions)) initOptions {
+	ops := initOptions{}
+	for _, option := range l.options {
+		option(&ops)
+	}
+	for _, option := range options {
+		option(&ops)
+	}
+	return ops
+}
+
+// setProvenance replaces the provenance of the Configuration
+func (l *Loader) setProvenance(table map[string]*Provenance, valueFiles []string) {
+	l.provenanceLock.Lock()
+	defer l.provenanceLock.Unlock()
+	l.provenance = table
+	l.valueFiles = valueFiles
+}
+
+// setStructProvenance replaces the provenance of the structures of the type s points to
+func (l *Loader) setStructProvenance(s interface{}, table map[string]*Provenance) {
+	l.provenanceLock.Lock()
+	defer l.provenanceLock.Unlock()
+	if l.structProvenance == nil {
+		l.structProvenance = make(map[reflect.Type]map[string]*Provenance)
+	}
+	l.structProvenance[reflect.TypeOf(s)] = table
+}
+
+// watchedFiles returns the files besides the config files whose changes trigger a reload:
+// the files named by _FILE keys and the files of DirectoryProviders
+func (l *Loader) watchedFiles() []string {
+	l.provenanceLock.RLock()
+	files := append([]string(nil), l.valueFiles...)
+	l.provenanceLock.RUnlock()
+	for _, p := range l.dataProviders() {
+		if d, ok := p.(interface{ watchedFiles() []string }); ok {
+			files = append(files, d.watchedFiles()...)
+		}
+	}
+	return files
+}
+
+// Explain returns the provenance of the field of the Configuration with the specified dotted
+// json path. See the package level Explain.
+func (l *Loader) Explain(path string) (Provenance, bool) {
+	l.provenanceLock.RLock()
+	defer l.provenanceLock.RUnlock()
+	return explain(l.provenance, path)
+}
+
+// Sources returns the provenance of every field of the Configuration that has a value, sorted
+// by path
+func (l *Loader) Sources() []Provenance {
+	l.provenanceLock.RLock()
+	defer l.provenanceLock.RUnlock()
+	return sources(l.provenance)
+}
+
+// ExplainFor returns the provenance of the field with the specified dotted json path of the
+// last structure of the type s points to filled by Load or ApplyExternalConfig. See the package
+// level ExplainFor.
+func (l *Loader) ExplainFor(s interface{}, path string) (Provenance, bool) {
+	l.provenanceLock.RLock()
+	defer l.provenanceLock.RUnlock()
+	return explain(l.structProvenance[reflect.TypeOf(s)], path)
+}
+
+// SourcesFor returns the provenance of every field that has a value of the last structure of
+// the type s points to filled by Load or ApplyExternalConfig, sorted by path
+func (l *Loader) SourcesFor(s interface{}) []Provenance {
+	l.provenanceLock.RLock()
+	defer l.provenanceLock.RUnlock()
+	return sources(l.structProvenance[reflect.TypeOf(s)])
+}
+
+func explain(table map[string]*Provenance, path string) (Provenance, bool) {
+	p, ok := table[path]
+	if !ok {
+		return Provenance{}, false
+	}
+	return *p, true
+}
+
+func sources(table map[string]*Provenance) []Provenance {
+	sources := make([]Provenance, 0, len(table))
+	for _, p := range table {
+		sources = append(sources, *p)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Path < sources[j].Path
+	})
+	return sources
+}
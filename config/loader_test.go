@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"grail/sysinfra/cfg/log"
+)
+
+// TestParallelLoaders uses several Loaders at the same time, each with its own config file,
+// defaults and patches. Run it with -race.
+func TestParallelLoaders(t *testing.T) {
+	level := log.GetDefaultLogger().GetLevel()
+	for i := 0; i < 4; i++ {
+		i := i
+		t.Run(fmt.Sprintf("loader%d", i), func(t *testing.T) {
+			t.Parallel()
+			file := filepath.Join(t.TempDir(), "config.json")
+			writeFile(t, file, fmt.Sprintf(`{"log_level":"debug","build":{"commit":"c%d"}}`, i))
+
+			l := NewLoader(ConfigFiles(file))
+			var changes sync.WaitGroup
+			l.OnChange(func(old, new *Configuration) {
+				changes.Done()
+			})
+			conf, err := l.Init(Defaults(Set(BRANCH, fmt.Sprintf("b%d", i))))
+			if err != nil {
+				t.Fatalf("Init() error = %v", err)
+			}
+			if conf.Build.Commit != fmt.Sprintf("c%d", i) || conf.Build.Branch != fmt.Sprintf("b%d", i) {
+				t.Errorf("Init() = %+v, want the commit of the file and the branch of the defaults", conf.Build)
+			}
+
+			changes.Add(1)
+			writeFile(t, file, fmt.Sprintf(`{"log_level":"debug","build":{"commit":"r%d"}}`, i))
+			if err := l.Reload(); err != nil {
+				t.Fatalf("Reload() error = %v", err)
+			}
+			changes.Add(1)
+			if _, err := l.Patch([]byte(fmt.Sprintf(`{"build":{"build_number":"%d"}}`, i))); err != nil {
+				t.Fatalf("Patch() error = %v", err)
+			}
+			changes.Wait()
+
+			got := l.Config().Build
+			if got.Commit != fmt.Sprintf("r%d", i) || got.BuildNumber != fmt.Sprint(i) {
+				t.Errorf("Config() = %+v, want the reloaded commit and the patched build number", got)
+			}
+			if p, ok := l.Explain("build.build_number"); !ok || p.Source.Name != patchSource {
+				t.Errorf("Explain(build.build_number) = %v, want the patch as source", p)
+			}
+		})
+	}
+	t.Cleanup(func() {
+		if got := log.GetDefaultLogger().GetLevel(); got != level {
+			t.Errorf("log level = %v, want %v: only the default Loader sets it", got, level)
+		}
+	})
+}
+
+func TestLoaderInitFromConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.json")
+	writeFile(t, file, `{"build":{"commit":"base"}}`)
+	missing := ConfigFiles(filepath.Join(dir, "missing.json"))
+
+	l := NewLoader(ConfigFiles(file))
+	l.InitFromConfigFiles()
+	other := NewLoader()
+	for _, loader := range []*Loader{l, other} {
+		if _, err := loader.Init(missing); err != nil {
+			t.Fatalf("Init() error = %v", err)
+		}
+	}
+	if got := l.Config().Build.Commit; got != "base" {
+		t.Errorf("commit = %q, want the commit of the base configuration", got)
+	}
+	if got := other.Config().Build.Commit; got != "" {
+		t.Errorf("commit of another Loader = %q, want none", got)
+	}
+}
+
+// TestLoaderClock expands date templates of config files and providers with the clock of each
+// Loader.
+func TestLoaderClock(t *testing.T) {
+	for i := 1; i <= 4; i++ {
+		i := i
+		t.Run(fmt.Sprintf("loader%d", i), func(t *testing.T) {
+			t.Parallel()
+			file := filepath.Join(t.TempDir(), "config.json")
+			writeFile(t, file, `{"build":{"commit":"{{date|tz=UTC}}"}}`)
+			now := time.Date(2024, 3, i, 23, 0, 0, 0, time.UTC)
+
+			l := NewLoader(ConfigFiles(file), Clock(func() time.Time { return now }))
+			conf, err := l.Init(Defaults(Set(BRANCH, "{{date:0,0,1|tz=UTC}}")))
+			if err != nil {
+				t.Fatalf("Init() error = %v", err)
+			}
+			want := fmt.Sprintf("2024-03-%02d", i)
+			if conf.Build.Commit != want {
+				t.Errorf("commit = %q, want %q", conf.Build.Commit, want)
+			}
+			if want := fmt.Sprintf("2024-03-%02d", i+1); conf.Build.Branch != want {
+				t.Errorf("branch = %q, want %q", conf.Build.Branch, want)
+			}
+		})
+	}
+}
+
+type serviceConfig struct {
+	Build `json:"build"`
+	Port  int `json:"port" env:"PORT" default:"8080"`
+}
+
+// TestDefaultsApplyToOneCall checks that the values of the Defaults option do not leak into
+// the other loads of the Loader, and that Load does not replace the provenance of the
+// Configuration.
+func TestDefaultsApplyToOneCall(t *testing.T) {
+	l := NewLoader(ConfigFiles())
+	if _, err := l.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	var service serviceConfig
+	if err := l.Load(&service, Defaults(Set(BRANCH, "x"), Set("PORT", "9090"))); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if service.Branch != "x" || service.Port != 9090 {
+		t.Errorf("Load() = %+v, want the values of Defaults", service)
+	}
+
+	if p, ok := l.Explain("build.version"); !ok || p.Source.Name != defaultTagSource {
+		t.Errorf("Explain(build.version) = %v, %v, want the default tag of the Configuration", p, ok)
+	}
+	if p, ok := l.Explain("port"); ok {
+		t.Errorf("Explain(port) = %v, want no field of another structure", p)
+	}
+	if p, ok := l.ExplainFor(&service, "port"); !ok || p.Source.Value != "9090" {
+		t.Errorf("ExplainFor(port) = %v, %v, want the value of Defaults", p, ok)
+	}
+	for _, p := range l.Sources() {
+		if p.Path == "port" {
+			t.Errorf("Sources() lists %v of another structure", p)
+		}
+	}
+
+	if err := l.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if branch := l.Config().Build.Branch; branch != "" {
+		t.Errorf("branch = %q after Reload, want none: Defaults of Load must not apply", branch)
+	}
+	var again serviceConfig
+	if err := l.Load(&again); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if again.Branch != "" || again.Port != 8080 {
+		t.Errorf("Load() = %+v, want no value of the Defaults of a previous call", again)
+	}
+
+	l.Defaults().Set("PORT", "7070")
+	if err := l.Load(&again, Defaults(Set("PORT", "9090"))); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if again.Port != 9090 {
+		t.Errorf("port = %d, want the Defaults of the call over the map of the Loader", again.Port)
+	}
+}
+
+func writeFile(t *testing.T, file string, content string) {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -7,10 +7,13 @@ import (
 	"grail/sysinfra/cfg/log"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"time"
 )
 
 const (
 	LOG_LEVEL    = "LOG_LEVEL"
+	LOG_FORMAT   = "LOG_FORMAT"
 	BRANCH       = "BRANCH"
 	BUILD_NUMBER = "BUILD_NUMBER"
 	COMMIT       = "COMMIT"
@@ -18,10 +21,10 @@ const (
 )
 
 type buildData struct {
-	Version     string `json:"version,omitempty" default:"1.3" env:"VERSION"`
-	Commit      string `json:"commit,omitempty" env:"COMMIT"`
-	Branch      string `json:"branch,omitempty" env:"BRANCH"`
-	BuildNumber string `json:"build_number,omitempty" env:"BUILD_NUMBER"`
+	Version     string `json:"version,omitempty" default:"1.3" env:"VERSION" desc:"application version"`
+	Commit      string `json:"commit,omitempty" env:"COMMIT" desc:"source commit"`
+	Branch      string `json:"branch,omitempty" env:"BRANCH" desc:"source branch"`
+	BuildNumber string `json:"build_number,omitempty" env:"BUILD_NUMBER" desc:"build number"`
 }
 
 // Build contains configuration data about the application version
@@ -30,12 +33,15 @@ type Build struct {
 }
 
 type Configuration struct {
-	LogLevel string `json:"log_level" env:"LOG_LEVEL"`
-	Build    Build  `json:"build"`
+	LogLevel  string `json:"log_level" env:"LOG_LEVEL" validate:"oneofci=DEBUG INFO WARN ERROR PANIC FATAL" desc:"minimum level of logged messages: DEBUG, INFO, WARN, ERROR, PANIC or FATAL, in any case"`
+	LogFormat string `json:"log_format" env:"LOG_FORMAT" validate:"oneof=text json logfmt" desc:"format of log lines: text, json or logfmt"`
+	Build     Build  `json:"build"`
 }
 
+// defaultConfiguration is the base configuration of new Loaders
 var defaultConfiguration = Configuration{
-	LogLevel: "INFO",
+	LogLevel:  "INFO",
+	LogFormat: log.FormatText,
 	Build: Build{
 		buildData: buildData{
 			Version: "1.0.0",
@@ -43,15 +49,22 @@ var defaultConfiguration = Configuration{
 	},
 }
 
-var configurationData Configuration
-
 // Config returns the configuration data
 func Config() *Configuration {
-	return &configurationData
+	return defaultLoader.Config()
 }
 
 type initOptions struct {
 	DefaultValues map[string]string
+	ConfigFiles   []string
+	ConfigDir     string
+	ConfigFormat  string
+	WatchInterval time.Duration
+	EnvPrefix     string
+	AutoEnv       bool
+	EncryptionKey []byte
+	Strict        bool
+	Clock         func() time.Time
 }
 
 // Set is a functional argument that you can pass to Defaults to set a default configuration value.
@@ -66,7 +79,8 @@ func Set(key string, value string) func(*initOptions) {
 }
 
 // Defaults is a functional argument you can pass to Init(). It's arguments would be one or more
-// calls to Set()
+// calls to Set(). The values only apply to the call they are passed to, and to the reloads it
+// starts, at a lower priority than the other providers.
 func Defaults(setters ...func(*initOptions)) func(*initOptions) {
 	return func(o *initOptions) {
 		for _, setter := range setters {
@@ -76,38 +90,80 @@ func Defaults(setters ...func(*initOptions)) func(*initOptions) {
 }
 
 // Init initializes the configuration module. It accepts zero or more functional arguments. Use
-// Defaults to specify a list of application defaults and EnableREST to register REST endpoints
-// for the configuration. For example:
-//     Init(Defaults(Set("DATASOURCE_HOST", "localhost")))
-//     Init(EnableREST)
+// Defaults to specify a list of application defaults. Handler serves REST endpoints for the
+// configuration. For example:
+//
+//	Init(Defaults(Set("DATASOURCE_HOST", "localhost")))
 func Init(options ...func(*initOptions)) (*Configuration, error) {
-	InitFromConfigFiles()
+	return defaultLoader.Init(options...)
+}
 
-	ops := initOptions{}
-	for _, option := range options {
-		option(&ops)
+// Load runs the same pipeline as Init against a new instance of the caller's own configuration
+// struct: the config file is read into it, then the Defaults and data providers are applied to
+// every field with an env tag and the validate tags are checked. Embed Build to get the
+// standard build fields. For example:
+//
+//	type ServiceConfig struct {
+//	    config.Build `json:"build"`
+//	    Port int     `json:"port" env:"PORT" default:"8080"`
+//	}
+//	conf, err := config.Load[ServiceConfig](Defaults(Set("PORT", "9090")))
+func Load[T any](options ...func(*initOptions)) (*T, error) {
+	conf := new(T)
+	err := defaultLoader.Load(conf, options...)
+	if err != nil {
+		return nil, err
 	}
-	configurationData = defaultConfiguration
+	return conf, nil
+}
 
-	// set default values
-	for key, value := range ops.DefaultValues {
-		DefaultMapProvider.Set(key, value)
+// decodeConfigFile decodes the content of a config file into conf. Every format is decoded the
+// same way, so that values such as durations are written the same in all of them. The values
+// read are returned by dotted json path. conf is left unchanged if the file cannot be decoded.
+func decodeConfigFile(filePath string, format string, data []byte, ops *initOptions,
+	conf interface{}) (map[string]string, error) {
+	parse, ok := parsers[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported format %q for config file %s", format, filePath)
 	}
-
-	// apply data from external data providers
-	err := ApplyExternalConfig(&configurationData, 4)
+	tree, err := parse(data)
+	if err != nil {
+		if format == FormatJSON {
+			return nil, jsonError(filePath, data, err)
+		}
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	// the values of JSON files are recorded as written
+	doc := data
+	if format != FormatJSON {
+		doc = treeToJSON(tree)
+	}
+	if err := checkUnknownKeys(filePath, format, data, tree, conf, ops.Strict); err != nil {
+		return nil, err
+	}
+	values, err := flattenJSON(doc, reflect.TypeOf(conf))
 	if err != nil {
-		return nil, fmt.Errorf("error resolving config values: %v", err)
+		return nil, fmt.Errorf("%s: %w", filePath, err)
 	}
-	var level log.Level
-	level.UnmarshalText([]byte(configurationData.LogLevel))
-	log.SetLevel(level)
-	b, err := json.Marshal(configurationData)
+	plain, err := decryptTree(tree, ops.EncryptionKey)
 	if err != nil {
-		log.Infof("Configuration: %s", string(b))
+		return nil, fmt.Errorf("%s: %w", filePath, err)
 	}
-
-	return &configurationData, nil
+	// decode into a copy, so that a value that cannot be converted does not leave the values
+	// decoded before it in conf
+	target := reflect.ValueOf(conf).Elem()
+	scratch := reflect.New(target.Type()).Elem()
+	scratch.Set(target)
+	deepCopy(scratch)
+	err = decodeTree(scratch, plain, "", ops.clock())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	target.Set(scratch)
+	if b, err := maskedJSON(conf); err == nil {
+		log.Printf("Default Config is %s", b)
+	}
+	return values, nil
 }
 
 // UpdateFromJSON merges any data from the specified json structure into the current configuration.
@@ -117,44 +173,42 @@ func UpdateFromJSON(jsonData string, obj interface{}) error {
 	return err
 }
 
+// InitFromConfigFiles reads the layered config files into the base configuration of the
+// default Loader, which Init starts from
 func InitFromConfigFiles() {
-	initFromConfigFile("./config.json")
+	defaultLoader.InitFromConfigFiles()
 }
 
-//Read configuration file
-func initFromConfigFile(filePath string) {
+// Read configuration file in the specified format into conf, decrypting encrypted values with
+// the EncryptionKey of ops. The values read are returned by the dotted json path of their field.
+// Unknown keys are an error in Strict mode. A missing file is not an error.
+func initFromConfigFile(filePath string, format string, ops *initOptions,
+	conf interface{}) (map[string]string, error) {
 	if stat, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
-		log.Infof("Config file does not exist")
-		return
+		log.Infof("Config file %s does not exist", filePath)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	} else if stat.IsDir() {
-		log.Infof("Config file path is a directory")
-		return
+		log.Infof("Config file path %s is a directory", filePath)
+		return nil, nil
 	}
 
-	// Open our jsonFile
-	jsonFile, err := os.Open(filePath)
-	// if we os.Open returns an error then handle it
+	// Open the config file, whatever its format
+	file, err := os.Open(filePath)
+	// if os.Open returns an error then handle it
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 	log.Printf("Successfully Opened %s", filePath)
-	// defer the closing of our jsonFile so that we can parse it later on
-	defer jsonFile.Close()
+	// defer the closing of the file so that we can parse it later on
+	defer file.Close()
 
-	// read our opened jsonFile as a byte array.
-	byteValue, err := ioutil.ReadAll(jsonFile)
+	// read the opened file as a byte array, parsed according to format
+	byteValue, err := ioutil.ReadAll(file)
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 
-	// we unmarshal our byteArray which contains our
-	// jsonFile's content into 'users' which we defined above
-	err = json.Unmarshal(byteValue, &defaultConfiguration)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	log.Printf("Default Config is %v", defaultConfiguration)
+	return decodeConfigFile(filePath, format, byteValue, ops, conf)
 }
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Source is a value offered for a configuration field by a provider, a config file or
+// a default tag
+type Source struct {
+	// Name is the provider name, the config file path or "default tag"
+	Name string `json:"name"`
+	// Value is the raw value supplied by the source
+	Value string `json:"value"`
+}
+
+// Provenance records which source supplied the value of a configuration field and the values
+// of lower priority sources that it shadowed. Secret fields are recorded like the others, with
+// the names of their sources, but all their values are masked as ******.
+type Provenance struct {
+	// Path is the dotted json path of the field, e.g. build.version
+	Path string `json:"path"`
+	// Key is the environment variable style key of the field, if it has one
+	Key      string   `json:"key,omitempty"`
+	Source   Source   `json:"source"`
+	Shadowed []Source `json:"shadowed,omitempty"`
+}
+
+// String formats the provenance as a single line, e.g.
+// build.version=2.0 from environment (VERSION), shadows ./config.json=1.3, default tag=1.3
+func (p Provenance) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s=%s from %s", p.Path, p.Source.Value, p.Source.Name)
+	if p.Key != "" {
+		fmt.Fprintf(&b, " (%s)", p.Key)
+	}
+	for i, s := range p.Shadowed {
+		if i == 0 {
+			b.WriteString(", shadows ")
+		} else {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s=%s", s.Name, s.Value)
+	}
+	return b.String()
+}
+
+// Explain returns the provenance of the field of the current Configuration with the specified
+// dotted json path, e.g. Explain("build.version"), as recorded by the last Init, reload or
+// patch. Fields that kept their initial value are not recorded. Use ExplainFor for the
+// structures filled by Load.
+func Explain(path string) (Provenance, bool) {
+	return defaultLoader.Explain(path)
+}
+
+// Sources returns the provenance of every field of the current Configuration that has a value,
+// sorted by path
+func Sources() []Provenance {
+	return defaultLoader.Sources()
+}
+
+// ExplainFor returns the provenance of the field with the specified dotted json path of the
+// last structure of the type s points to filled by Load or ApplyExternalConfig, e.g.
+// ExplainFor(&ServiceConfig{}, "port"). Each type has its own record, separate from the
+// Configuration's.
+func ExplainFor(s interface{}, path string) (Provenance, bool) {
+	return defaultLoader.ExplainFor(s, path)
+}
+
+// SourcesFor returns the provenance of every field that has a value of the last structure of
+// the type s points to filled by Load or ApplyExternalConfig, sorted by path
+func SourcesFor(s interface{}) []Provenance {
+	return defaultLoader.SourcesFor(s)
+}
+
+// addFileValues records the values read from a config file. Files are read in increasing order
+// of priority, so the values of filePath shadow those of the files read before it.
+func addFileValues(fileValues map[string][]Source, filePath string, values map[string]string) {
+	for path, value := range values {
+		fileValues[path] = append([]Source{{Name: filePath, Value: value}}, fileValues[path]...)
+	}
+}
+
+// flattenJSON returns the leaf values of a json document by dotted path. Arrays are treated
+// as leaves. The keys that match a field of the struct type t, ignoring case as encoding/json
+// does, are replaced by the json path of the field, so that the values are found by path.
+func flattenJSON(data []byte, t reflect.Type) (map[string]string, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var doc map[string]interface{}
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	flattenFields(t, "", doc, values)
+	return values, nil
+}
+
+// flattenFields is flattenValue for the value of a field of type t, which may be nil when the
+// value does not belong to a struct field. The objects of fields decoded by UnmarshalJSON are
+// leaves.
+func flattenFields(t reflect.Type, path string, v interface{}, values map[string]string) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	obj, ok := v.(map[string]interface{})
+	if ok && t != nil && isJSONLeaf(t) {
+		b, _ := json.Marshal(obj)
+		values[path] = string(b)
+		return
+	}
+	if !ok || t == nil || t.Kind() != reflect.Struct {
+		if ok {
+			for key, child := range obj {
+				flattenFields(nil, joinPath(path, key), child, values)
+			}
+			return
+		}
+		flattenValue(path, v, values)
+		return
+	}
+	fields := jsonFields(t)
+	for key, child := range obj {
+		index, ok := fields[strings.ToLower(key)]
+		if !ok {
+			flattenFields(nil, joinPath(path, key), child, values)
+			continue
+		}
+		childPath, ft := matchedField(t, path, index)
+		flattenFields(ft, childPath, child, values)
+	}
+}
+
+// matchedField returns the json path, as recorded by the walker, and the type of the field
+// of the struct type t with the index returned by jsonFields
+func matchedField(t reflect.Type, path string, index []int) (string, reflect.Type) {
+	for _, i := range index {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		ft := t.Field(i)
+		path = fieldPath(path, ft)
+		t = ft.Type
+	}
+	return path, t
+}
+
+func flattenValue(path string, v interface{}, values map[string]string) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, child := range val {
+			if path != "" {
+				key = path + "." + key
+			}
+			flattenValue(key, child, values)
+		}
+	case string:
+		values[path] = val
+	default:
+		b, _ := json.Marshal(val)
+		values[path] = string(b)
+	}
+}
@@ -1,11 +1,17 @@
 package config
 
 import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"grail/sysinfra/cfg/log"
@@ -16,107 +22,209 @@ type KeyValueProvider interface {
 	Get(key string) (string, error)
 }
 
+// LookupProvider is implemented by providers that can tell a key that is not set from a key
+// set to an empty string. A key found with an empty value overrides lower priority sources and
+// resets the field to its zero value. Providers that only implement Get are treated as not
+// having keys whose value is empty.
+type LookupProvider interface {
+	Lookup(key string) (value string, found bool, err error)
+}
+
+// lookup fetches the value of key from p, through Lookup if p implements LookupProvider
+func lookup(p KeyValueProvider, key string) (string, bool, error) {
+	if l, ok := p.(LookupProvider); ok {
+		return l.Lookup(key)
+	}
+	v, err := p.Get(key)
+	return v, err == nil && v != "", err
+}
+
 // EnvironmentProvider is used to update the configuration from environment variables
-type EnvironmentProvider struct{}
+type EnvironmentProvider struct {
+	// Prefix is prepended to the keys, separated by an underscore, e.g. MYAPP
+	Prefix string
+}
 
 // Get fetches the environment variable value for the specified key. An empty string
 // is returned if not found.
 func (e EnvironmentProvider) Get(key string) (string, error) {
-	return os.Getenv(key), nil
+	return os.Getenv(prefixedKey(e.Prefix, key)), nil
+}
+
+// Lookup fetches the environment variable value for the specified key and reports whether
+// the variable is set.
+func (e EnvironmentProvider) Lookup(key string) (string, bool, error) {
+	v, found := os.LookupEnv(prefixedKey(e.Prefix, key))
+	return v, found, nil
+}
+
+// Name identifies the provider in errors
+func (e EnvironmentProvider) Name() string {
+	if e.Prefix != "" {
+		return "environment " + prefixedKey(e.Prefix, "*")
+	}
+	return "environment"
 }
 
 // MapProvider is used to update the configuration from a map that has been initialized
-// by the application
+// by the application. It is safe for concurrent use.
 type MapProvider struct {
+	lock  sync.RWMutex
 	store map[string]string
 }
 
 // Get fetches the mapped value for the specified key. An empty string
 // is returned if not found.
 func (m *MapProvider) Get(key string) (string, error) {
-	if m.store == nil {
-		m.store = make(map[string]string)
-	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	return m.store[key], nil
 }
 
+// Lookup fetches the mapped value for the specified key and reports whether the key is in
+// the map.
+func (m *MapProvider) Lookup(key string) (string, bool, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	v, found := m.store[key]
+	return v, found, nil
+}
+
 // Set sets a value in the map. The key should match the field's environment variable name.
 func (m *MapProvider) Set(key string, value string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	if m.store == nil {
 		m.store = make(map[string]string)
 	}
 	m.store[key] = value
 }
 
-// DefaultMapProvider is the built in map provider
-var DefaultMapProvider = &MapProvider{}
+// Name identifies the provider in errors
+func (m *MapProvider) Name() string {
+	return "map"
+}
 
-// default data providers in decreasing order of priority
-var dataProviders = []KeyValueProvider{&EnvironmentProvider{}, DefaultMapProvider}
+// DefaultMapProvider is the built in map provider of the default Loader. Its values apply to
+// every load, unlike the values of the Defaults option.
+var DefaultMapProvider = &MapProvider{}
 
 // ClearDataProviders removes all configuration data providers
 func ClearDataProviders() {
-	dataProviders = nil
+	defaultLoader.ClearDataProviders()
 }
 
 // AddDataProvider adds a new KeyValueProvider to the list of providers
 // that are checked for configuration values. The list is in descending order
 // of priority.
 func AddDataProvider(p KeyValueProvider) {
-	dataProviders = append(dataProviders, p)
+	defaultLoader.AddDataProvider(p)
+}
+
+// PrependDataProvider adds a new KeyValueProvider with a higher priority than all the
+// providers already in the list, for example a FlagProvider.
+func PrependDataProvider(p KeyValueProvider) {
+	defaultLoader.PrependDataProvider(p)
 }
 
 // ApplyExternalConfig walks through the specified configuration data structure and
-// updates the configuration fields from the configured data providers
+// updates the configuration fields from the configured data providers. Values that cannot
+// be converted to the field type and providers that fail are reported together as FieldErrors.
+// The source of every value is recorded and can be queried with ExplainFor.
 func ApplyExternalConfig(s interface{}, maxDepth int) error {
-	walkStruct(reflect.ValueOf(s).Elem(), maxDepth)
-	return nil
+	return defaultLoader.ApplyExternalConfig(s, maxDepth)
+}
+
+// providerName returns the name used for p in errors. Providers may implement
+// a Name() string method, otherwise the type name is used.
+func providerName(p KeyValueProvider) string {
+	if named, ok := p.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", p)
 }
 
-// getValue searches the configured providers for the highest priority provider
-// that has a value available for the specified key.
-// nolint:unused,deadcode
-func getValue(key string) string {
-	for _, prov := range dataProviders {
-		v, err := prov.Get(key)
-		if err == nil && v != "" {
-			return v
+// getValues returns the values available for the specified key from all the providers of the
+// walker, in decreasing order of priority. A provider that does not have the key but has the
+// key with a _FILE suffix supplies the content of the file it names. Errors of the providers
+// that failed are collected in errs.
+func (w *walker) getValues(key string) (values []Source, errs []providerError) {
+	for _, prov := range w.providers {
+		prov = w.withEnvPrefix(prov)
+		name := providerName(prov)
+		v, found, err := lookup(prov, key)
+		if err == nil && !found {
+			var path string
+			path, found, err = lookup(prov, key+fileKeySuffix)
+			if err == nil && found {
+				w.files = append(w.files, path)
+				name = fmt.Sprintf("%s (%s%s=%s)", name, key, fileKeySuffix, path)
+				v, err = readValueFile(path)
+			}
+		}
+		if err != nil {
+			errs = append(errs, providerError{name, err})
+			continue
+		}
+		if found {
+			values = append(values, Source{Name: name, Value: v})
 		}
 	}
-	return ""
+	return values, errs
 }
 
-// getValueDefault searches the configured providers for the highest priority provider
-// that has a value available for the specified key. Else return envDefault if it is non-empty on
-// failure of all searhces
-func getValueDefault(key string, envDefault string) string {
-	for _, prov := range dataProviders {
-		v, err := prov.Get(key)
-		if err == nil && v != "" {
-			return v
+// withEnvPrefix returns an EnvironmentProvider using the prefix of the walker in place of
+// an EnvironmentProvider without a prefix
+func (w *walker) withEnvPrefix(p KeyValueProvider) KeyValueProvider {
+	if w.envPrefix == "" {
+		return p
+	}
+	switch e := p.(type) {
+	case EnvironmentProvider:
+		if e.Prefix == "" {
+			return EnvironmentProvider{Prefix: w.envPrefix}
+		}
+	case *EnvironmentProvider:
+		if e.Prefix == "" {
+			return &EnvironmentProvider{Prefix: w.envPrefix}
 		}
 	}
-	return envDefault
+	return p
+}
+
+// defaultTagSource is the source name reported for values taken from a field's default tag
+const defaultTagSource = "default tag"
+
+type providerError struct {
+	provider string
+	err      error
 }
 
-var setters map[reflect.Kind]func(field reflect.Value, value string)
+var (
+	setters     map[reflect.Kind]func(field reflect.Value, value string) error
+	settersOnce sync.Once
+)
 
 // initSetters creates setters for many built in data types
 func initSetters() {
 	// adapted from https://github.com/mcuadros/go-defaults (MIT)
-	setters = make(map[reflect.Kind]func(field reflect.Value, value string))
-	setters[reflect.Bool] = func(field reflect.Value, value string) {
+	setters = make(map[reflect.Kind]func(field reflect.Value, value string) error)
+	setters[reflect.Bool] = func(field reflect.Value, value string) error {
 		val, err := strconv.ParseBool(value)
-		if err == nil {
-			field.SetBool(val)
+		if err != nil {
+			return err
 		}
+		field.SetBool(val)
+		return nil
 	}
 
-	setters[reflect.Int] = func(field reflect.Value, value string) {
-		val, err := strconv.ParseInt(value, 10, 64)
-		if err == nil {
-			field.SetInt(val)
+	setters[reflect.Int] = func(field reflect.Value, value string) error {
+		val, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
 		}
+		field.SetInt(val)
+		return nil
 	}
 
 	setters[reflect.Int8] = setters[reflect.Int]
@@ -124,20 +232,24 @@ func initSetters() {
 	setters[reflect.Int32] = setters[reflect.Int]
 	setters[reflect.Int64] = setters[reflect.Int]
 
-	setters[reflect.Float32] = func(field reflect.Value, value string) {
-		val, err := strconv.ParseFloat(value, 64)
-		if err == nil {
-			field.SetFloat(val)
+	setters[reflect.Float32] = func(field reflect.Value, value string) error {
+		val, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
 		}
+		field.SetFloat(val)
+		return nil
 	}
 
 	setters[reflect.Float64] = setters[reflect.Float32]
 
-	setters[reflect.Uint] = func(field reflect.Value, value string) {
-		val, err := strconv.ParseUint(value, 10, 64)
-		if err == nil {
-			field.SetUint(val)
+	setters[reflect.Uint] = func(field reflect.Value, value string) error {
+		val, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
 		}
+		field.SetUint(val)
+		return nil
 	}
 
 	setters[reflect.Uint8] = setters[reflect.Uint]
@@ -145,69 +257,220 @@ func initSetters() {
 	setters[reflect.Uint32] = setters[reflect.Uint]
 	setters[reflect.Uint64] = setters[reflect.Uint]
 
-	setters[reflect.String] = func(field reflect.Value, value string) {
-		val := parseDateTimeString(value)
-		field.SetString(val)
+	setters[reflect.String] = func(field reflect.Value, value string) error {
+		field.SetString(value)
+		return nil
 	}
 }
-func parseDateTimeString(data string) string {
 
-	pattern := regexp.MustCompile(`\{\{(\w+\:(?:-|)\d*,(?:-|)\d*,(?:-|)\d*)\}\}`)
-	matches := pattern.FindAllStringSubmatch(data, -1) // matches is [][]string
-	for _, match := range matches {
+// RegisterSetter teaches the configuration walker how to convert a string value to the type t.
+// The value returned by setter must be convertible to t. Registered setters take precedence over
+// encoding.TextUnmarshaler, json.Unmarshaler and the built in setters. For example:
+//
+//	config.RegisterSetter(reflect.TypeOf(Color(0)), func(s string) (any, error) {
+//		return ParseColor(s)
+//	})
+func RegisterSetter(t reflect.Type, setter func(value string) (any, error)) {
+	convertersLock.Lock()
+	defer convertersLock.Unlock()
+	converters[t] = setter
+}
 
-		tags := strings.Split(match[1], ":")
-		if len(tags) == 2 {
+var convertersLock sync.RWMutex
+
+// converters parse values of types whose kind alone does not determine how to read them,
+// including any registered with RegisterSetter.
+var converters = map[reflect.Type]func(value string) (interface{}, error){
+	reflect.TypeOf(time.Duration(0)): func(value string) (interface{}, error) {
+		return time.ParseDuration(value)
+	},
+	reflect.TypeOf(time.Time{}): func(value string) (interface{}, error) {
+		return time.Parse(time.RFC3339, value)
+	},
+	reflect.TypeOf(net.IP{}): func(value string) (interface{}, error) {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", value)
+		}
+		return ip, nil
+	},
+	reflect.TypeOf(url.URL{}): func(value string) (interface{}, error) {
+		u, err := url.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		return *u, nil
+	},
+}
 
-			valueStrings := strings.Split(tags[1], ",")
-			if len(valueStrings) == 3 {
-				var values [3]int
-				for key, valueString := range valueStrings {
-					num, _ := strconv.ParseInt(valueString, 10, 64)
-					values[key] = int(num)
-				}
+func isStruct(fv reflect.Value, ft reflect.StructField) bool {
+	return ft.Type.PkgPath() != "" && fv.Kind() == reflect.Struct
+}
 
-				switch tags[0] {
-
-				case "date":
-					str := time.Now().AddDate(values[0], values[1], values[2]).Format("2006-01-02")
-					data = strings.Replace(data, match[0], str, -1)
-					break
-				case "time":
-					str := time.Now().Add((time.Duration(values[0]) * time.Hour) +
-						(time.Duration(values[1]) * time.Minute) +
-						(time.Duration(values[2]) * time.Second)).Format("15:04:05")
-					data = strings.Replace(data, match[0], str, -1)
-					break
-				}
+// isLeaf reports whether values of type t are set from a single value even though t is a
+// struct, such as time.Time or url.URL
+func isLeaf(t reflect.Type) bool {
+	convertersLock.RLock()
+	_, ok := converters[t]
+	convertersLock.RUnlock()
+	return ok || reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// setValue sets the value of the specified field to the specified value. Slice and map
+// fields are split using the separator in the field's sep tag, "," by default. An empty
+// value resets the field to its zero value.
+func setValue(field reflect.Value, fieldType reflect.StructField, value string) error {
+	if !(field.IsValid() && field.CanSet()) {
+		return nil
+	}
+	if value == "" {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	sep := fieldType.Tag.Get("sep")
+	if sep == "" {
+		sep = ","
+	}
+	err := setFieldValue(field, value, sep)
+	if errors.Is(err, errNoSetter) {
+		log.Warnf("did not find setter for field %s kind = %d", fieldType.Name, int(fieldType.Type.Kind()))
+		return nil
+	}
+	return err
+}
+
+var errNoSetter = errors.New("no setter for type")
+
+// setFieldValue converts value to the type of field and stores it. Pointers are allocated,
+// slices hold one element per separated item and maps are read from key=value items.
+func setFieldValue(field reflect.Value, value string, sep string) error {
+	settersOnce.Do(initSetters)
+	t := field.Type()
+	convertersLock.RLock()
+	convert := converters[t]
+	convertersLock.RUnlock()
+	if convert != nil {
+		val, err := convert(value)
+		if err != nil {
+			return err
+		}
+		rv := reflect.ValueOf(val)
+		if !rv.IsValid() || !rv.Type().ConvertibleTo(t) {
+			return fmt.Errorf("setter for %s returned %T", t, val)
+		}
+		field.Set(rv.Convert(t))
+		return nil
+	}
+	if field.CanAddr() {
+		switch u := field.Addr().Interface().(type) {
+		case encoding.TextUnmarshaler:
+			return u.UnmarshalText([]byte(value))
+		case json.Unmarshaler:
+			data := []byte(value)
+			if !json.Valid(data) {
+				data, _ = json.Marshal(value)
 			}
+			return u.UnmarshalJSON(data)
 		}
+	}
 
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(t.Elem())
+		if err := setFieldValue(elem.Elem(), value, sep); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			field.SetBytes([]byte(value))
+			return nil
+		}
+		items := strings.Split(value, sep)
+		slice := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			if err := setFieldValue(slice.Index(i), strings.TrimSpace(item), sep); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	case reflect.Map:
+		items := strings.Split(value, sep)
+		m := reflect.MakeMapWithSize(t, len(items))
+		for _, item := range items {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("map item %q is not in key=value format", item)
+			}
+			key := reflect.New(t.Key()).Elem()
+			if err := setFieldValue(key, strings.TrimSpace(kv[0]), sep); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := setFieldValue(elem, strings.TrimSpace(kv[1]), sep); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		field.Set(m)
+		return nil
 	}
-	return data
-}
-func isStruct(fv reflect.Value, ft reflect.StructField) bool {
-	return ft.Type.PkgPath() != "" && fv.Kind() == reflect.Struct
+
+	setter := setters[t.Kind()]
+	if setter == nil {
+		return errNoSetter
+	}
+	return setter(field, value)
 }
 
-// setValue sets the value of the specified field to the specified value
-func setValue(field reflect.Value, fieldType reflect.StructField, value string) {
-	if !(field.IsValid() && field.CanSet()) {
-		return
+// fieldPath appends the json name of the field to the dotted path of its parent. Embedded
+// structs without a json name do not add a path element, matching encoding/json.
+func fieldPath(parent string, ft reflect.StructField) string {
+	name := strings.Split(ft.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		name = ""
 	}
-	if setters == nil {
-		initSetters()
+	if name == "" {
+		if ft.Anonymous {
+			return parent
+		}
+		name = ft.Name
 	}
-	kind := fieldType.Type.Kind()
-	setter := setters[kind]
-	if setter != nil {
-		setter(field, value)
-	} else {
-		log.Warnf("did not find setter for field %s kind = %d", fieldType.Name, int(kind))
+	if parent == "" {
+		return name
 	}
+	return parent + "." + name
 }
 
-func walkStruct(v reflect.Value, maxDepth int) {
+// walker holds the state of a single pass over a configuration structure
+type walker struct {
+	// providers are the data providers in decreasing order of priority
+	providers []KeyValueProvider
+	// fileValues holds the values read from config files by field path
+	fileValues map[string][]Source
+	// table records the provenance of every field that has a value
+	table map[string]*Provenance
+	errs  FieldErrors
+	// envPrefix is applied to the keys of EnvironmentProviders without their own prefix
+	envPrefix string
+	// autoEnv derives the key of fields without an env tag from their path
+	autoEnv bool
+	// files lists the files named by _FILE keys
+	files []string
+	// key decrypts encrypted values
+	key []byte
+	// fields holds the fields by key, for the references of interpolated values
+	fields map[string]fieldRef
+	// now is the clock of date and time templates
+	now func() time.Time
+}
+
+// walkStruct calls visit for every field that has an env tag, or every exported leaf field
+// with AutoEnv, with the key of the field
+func (w *walker) walkStruct(v reflect.Value, path string, maxDepth int,
+	visit func(fv reflect.Value, ft reflect.StructField, path string, key string)) {
 	t := v.Type()
 	log.Debugf("walk: %s %d", t.Name(), maxDepth)
 
@@ -219,17 +482,64 @@ func walkStruct(v reflect.Value, maxDepth int) {
 		tag := ft.Tag.Get("env")
 
 		if tag == "" {
-			if maxDepth > 0 && isStruct(fv, ft) {
-				walkStruct(fv, maxDepth-1)
+			if isStruct(fv, ft) && !isLeaf(ft.Type) {
+				if maxDepth > 0 {
+					w.walkStruct(fv, fieldPath(path, ft), maxDepth-1, visit)
+				}
+				continue
 			}
-			continue
+			if !w.autoEnv || ft.PkgPath != "" {
+				continue
+			}
+			tag = envKey(fieldPath(path, ft))
 		}
 
 		//log.Printf("found tag %s for field %s\n", tag, ft.Name)
-		defaultTag := ft.Tag.Get("default")
-		if envValue := getValueDefault(tag, defaultTag); envValue != "" {
-			log.Debugf("setting %s to %s", ft.Name, envValue)
-			setValue(fv, ft, envValue)
-		}
+		visit(fv, ft, fieldPath(path, ft), tag)
+	}
+}
+
+// walkField resolves the value of a field with an env tag. Providers take precedence over
+// config files, which take precedence over the default tag. ${KEY} references are expanded
+// and encrypted values decrypted when the value is set, the provenance keeps the raw value.
+func (w *walker) walkField(fv reflect.Value, ft reflect.StructField, path string, key string) {
+	values, provErrs := w.getValues(key)
+	for _, pe := range provErrs {
+		w.errs = append(w.errs, &FieldError{Path: path, Key: key, Kind: ft.Type.Kind(),
+			Provider: pe.provider, Err: pe.err})
+	}
+	fromProviders := len(values)
+	values = append(values, w.fileValues[path]...)
+	if defaultTag := ft.Tag.Get("default"); defaultTag != "" {
+		values = append(values, Source{Name: defaultTagSource, Value: defaultTag})
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	winner := values[0]
+	w.table[path] = &Provenance{Path: path, Key: key, Source: winner, Shadowed: values[1:]}
+	if fromProviders == 0 && len(w.fileValues[path]) > 0 && !strings.Contains(winner.Value, "${") {
+		// already set when the config file was read
+		return
+	}
+	value := winner.Value
+	if isSecret(ft) {
+		value = maskedValue
+	}
+	log.Debugf("setting %s to %s", ft.Name, value)
+	plain, err := w.expand(winner.Value, []string{key})
+	if err == nil {
+		plain, err = Decrypt(plain, w.key)
+	}
+	if err == nil && expandsDateTime(ft.Type) {
+		plain, err = expandDateTime(plain, w.now)
+	}
+	if err == nil {
+		err = setValue(fv, ft, plain)
+	}
+	if err != nil {
+		w.errs = append(w.errs, &FieldError{Path: path, Key: key, Value: value,
+			Kind: ft.Type.Kind(), Provider: winner.Name, Err: err})
 	}
 }
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schemaDialect is the JSON Schema version of the documents generated by Schema
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchema is a JSON Schema document or subschema. The x-env keyword holds the key of the
+// field and x-required marks fields whose validate tag has the required rule: the value may
+// come from a provider, so the field is not required in config files.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	MinProperties        *int                   `json:"minProperties,omitempty"`
+	MaxProperties        *int                   `json:"maxProperties,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	WriteOnly            bool                   `json:"writeOnly,omitempty"`
+	Env                  string                 `json:"x-env,omitempty"`
+	Required             bool                   `json:"x-required,omitempty"`
+}
+
+// Schema returns a JSON Schema document describing the config files of the configuration
+// structure s points to. Properties are named after the json names of the fields and nested
+// structures are walked as Init does. Each property has:
+//   - its type and the default value of its default tag, except for secrets
+//   - its description from the desc tag
+//   - its environment variable in the x-env keyword, honoring the EnvPrefix and AutoEnv options
+//   - the constraints of its validate tag: min and max, oneof as enum, pattern, and required
+//     as x-required. The enum of oneofci lists the values in upper and lower case.
+//
+// Durations are strings such as "30s" or "1h30m", as time.ParseDuration reads them.
+func Schema(s interface{}, options ...func(*initOptions)) ([]byte, error) {
+	ops := initOptions{}
+	for _, option := range options {
+		option(&ops)
+	}
+	t := reflect.TypeOf(s).Elem()
+	root := &jsonSchema{Schema: schemaDialect, Title: t.Name(), Type: "object",
+		Properties: make(map[string]*jsonSchema)}
+	addProperties(root, t, "", 4, &ops)
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// addProperties adds the properties of the fields of the struct type t to parent
+func addProperties(parent *jsonSchema, t reflect.Type, path string, maxDepth int, ops *initOptions) {
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		name := strings.Split(ft.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		ftype := indirectType(ft.Type)
+		if name == "" && ft.Anonymous && ftype.Kind() == reflect.Struct && !isLeaf(ftype) {
+			if maxDepth > 0 {
+				addProperties(parent, ftype, path, maxDepth-1, ops)
+			}
+			continue
+		}
+		if ft.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = ft.Name
+		}
+		if _, ok := parent.Properties[name]; ok {
+			continue
+		}
+		fieldPath := fieldPath(path, ft)
+
+		if ftype.Kind() == reflect.Struct && !isLeaf(ftype) {
+			if maxDepth > 0 {
+				child := &jsonSchema{Type: "object", Description: ft.Tag.Get("desc"),
+					Properties: make(map[string]*jsonSchema)}
+				addProperties(child, ftype, fieldPath, maxDepth-1, ops)
+				parent.Properties[name] = child
+			}
+			continue
+		}
+
+		prop := typeSchema(ftype)
+		prop.Description = ft.Tag.Get("desc")
+		if key := ft.Tag.Get("env"); key != "" {
+			prop.Env = prefixedKey(ops.EnvPrefix, key)
+		} else if ops.AutoEnv {
+			prop.Env = prefixedKey(ops.EnvPrefix, envKey(fieldPath))
+		}
+		if isSecret(ft) {
+			prop.WriteOnly = true
+		} else if defaultTag := ft.Tag.Get("default"); defaultTag != "" {
+			prop.Default = schemaValue(ft, defaultTag)
+		}
+		addConstraints(prop, ft, ftype)
+		parent.Properties[name] = prop
+	}
+}
+
+// typeSchema returns the schema of values of type t, which is not a struct walked by addProperties
+func typeSchema(t reflect.Type) *jsonSchema {
+	switch t {
+	case durationType:
+		return &jsonSchema{Type: "string", Pattern: durationPattern}
+	case reflect.TypeOf(time.Time{}):
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	}
+	if isLeaf(t) {
+		return &jsonSchema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &jsonSchema{Type: "integer", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json reads []byte from base64 strings
+			return &jsonSchema{Type: "string", Format: "byte"}
+		}
+		return &jsonSchema{Type: "array", Items: elemSchema(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: elemSchema(t.Elem())}
+	}
+	return &jsonSchema{}
+}
+
+// elemSchema returns the schema of the elements of slices and maps
+func elemSchema(t reflect.Type) *jsonSchema {
+	t = indirectType(t)
+	if t.Kind() == reflect.Struct && !isLeaf(t) {
+		s := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema)}
+		addProperties(s, t, "", 0, &initOptions{})
+		return s
+	}
+	return typeSchema(t)
+}
+
+// addConstraints adds the rules of the validate tag of the field to its schema
+func addConstraints(prop *jsonSchema, ft reflect.StructField, t reflect.Type) {
+	for _, rule := range splitRules(ft.Tag.Get("validate")) {
+		name, param := rule, ""
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "required":
+			prop.Required = true
+		case "min", "max":
+			addBound(prop, t, name, param)
+		case "oneof":
+			for _, value := range strings.Fields(param) {
+				prop.Enum = append(prop.Enum, schemaValue(ft, value))
+			}
+		case "oneofci":
+			for _, value := range strings.Fields(param) {
+				prop.Enum = append(prop.Enum, schemaValue(ft, strings.ToUpper(value)))
+				if lower := strings.ToLower(value); lower != strings.ToUpper(value) {
+					prop.Enum = append(prop.Enum, schemaValue(ft, lower))
+				}
+			}
+		case "pattern":
+			prop.Pattern = param
+		}
+	}
+}
+
+// durationPattern matches the durations accepted by time.ParseDuration
+const durationPattern = `^[-+]?(0|(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+)$`
+
+// addBound sets the minimum or maximum of numbers, or the bounds of the length of strings,
+// arrays and objects. The bounds of durations cannot be expressed on their text.
+func addBound(prop *jsonSchema, t reflect.Type, name string, param string) {
+	if t == durationType {
+		return
+	}
+	bound, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	length := int(bound)
+	switch prop.Type {
+	case "integer", "number":
+		if name == "min" {
+			prop.Minimum = &bound
+		} else {
+			prop.Maximum = &bound
+		}
+	case "string":
+		if name == "min" {
+			prop.MinLength = &length
+		} else {
+			prop.MaxLength = &length
+		}
+	case "array":
+		if name == "min" {
+			prop.MinItems = &length
+		} else {
+			prop.MaxItems = &length
+		}
+	case "object":
+		if name == "min" {
+			prop.MinProperties = &length
+		} else {
+			prop.MaxProperties = &length
+		}
+	}
+}
+
+// schemaValue converts a value of a tag to the JSON value of the field. Strings and values of
+// leaf types such as time.Time are kept as written, so that templates are not expanded.
+func schemaValue(ft reflect.StructField, value string) interface{} {
+	t := indirectType(ft.Type)
+	if t.Kind() == reflect.String || isLeaf(t) {
+		return value
+	}
+	v := reflect.New(t).Elem()
+	if err := setValue(v, ft, value); err != nil {
+		return value
+	}
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return value
+	}
+	var result interface{}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return value
+	}
+	return result
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
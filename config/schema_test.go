@@ -0,0 +1,119 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type schemaServer struct {
+	Host string `json:"host" env:"HOST" default:"localhost" desc:"address to listen on"`
+	Port uint16 `json:"port" default:"8080" validate:"min=1,max=65535"`
+}
+
+type schemaBackend struct {
+	URL    string `json:"url" validate:"required"`
+	Weight int    `json:"weight" default:"1"`
+}
+
+type schemaEmbedded struct {
+	Region string `json:"region" validate:"oneof=eu us"`
+}
+
+type schemaConfig struct {
+	schemaEmbedded
+	Server   schemaServer      `json:"server" desc:"HTTP server"`
+	Timeout  time.Duration     `json:"timeout" default:"30s" validate:"min=1s"`
+	Started  time.Time         `json:"started"`
+	Ratio    float64           `json:"ratio" default:"0.5"`
+	Debug    bool              `json:"debug" default:"true"`
+	Tags     []string          `json:"tags" validate:"min=1"`
+	Labels   map[string]string `json:"labels" validate:"max=10"`
+	Backends []schemaBackend   `json:"backends"`
+	Cert     []byte            `json:"cert"`
+	Password Secret            `json:"password" env:"PASSWORD" default:"changeme" validate:"required"`
+	Name     string            `json:"name" validate:"max=20,pattern=^[a-z,]+$"`
+	Ignored  string            `json:"-"`
+	internal string
+}
+
+const wantSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "schemaConfig",
+  "type": "object",
+  "properties": {
+    "region": {"type": "string", "enum": ["eu", "us"], "x-env": "APP_REGION"},
+    "server": {
+      "description": "HTTP server",
+      "type": "object",
+      "properties": {
+        "host": {"description": "address to listen on", "type": "string", "default": "localhost", "x-env": "APP_HOST"},
+        "port": {"type": "integer", "default": 8080, "minimum": 1, "maximum": 65535, "x-env": "APP_SERVER_PORT"}
+      }
+    },
+    "timeout": {"type": "string", "default": "30s", "pattern": "^[-+]?(0|(([0-9]+(\\.[0-9]*)?|\\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+)$", "x-env": "APP_TIMEOUT"},
+    "started": {"type": "string", "format": "date-time", "x-env": "APP_STARTED"},
+    "ratio": {"type": "number", "default": 0.5, "x-env": "APP_RATIO"},
+    "debug": {"type": "boolean", "default": true, "x-env": "APP_DEBUG"},
+    "tags": {"type": "array", "items": {"type": "string"}, "minItems": 1, "x-env": "APP_TAGS"},
+    "labels": {"type": "object", "additionalProperties": {"type": "string"}, "maxProperties": 10, "x-env": "APP_LABELS"},
+    "backends": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "url": {"type": "string", "x-required": true},
+          "weight": {"type": "integer", "default": 1}
+        }
+      },
+      "x-env": "APP_BACKENDS"
+    },
+    "cert": {"type": "string", "format": "byte", "x-env": "APP_CERT"},
+    "password": {"type": "string", "writeOnly": true, "x-env": "APP_PASSWORD", "x-required": true},
+    "name": {"type": "string", "maxLength": 20, "pattern": "^[a-z,]+$", "x-env": "APP_NAME"}
+  }
+}`
+
+func TestSchema(t *testing.T) {
+	b, err := Schema(&schemaConfig{}, EnvPrefix("APP"), AutoEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(wantSchema), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Schema() =\n%s\nwant\n%s", b, wantSchema)
+	}
+}
+
+// TestSchemaEnv only lists the env tags of the fields without AutoEnv
+func TestSchemaEnv(t *testing.T) {
+	b, err := Schema(&schemaConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema jsonSchema
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{}
+	for name, prop := range schema.Properties {
+		if prop.Env != "" {
+			env[name] = prop.Env
+		}
+		for child, prop := range prop.Properties {
+			if prop.Env != "" {
+				env[name+"."+child] = prop.Env
+			}
+		}
+	}
+	if want := map[string]string{"server.host": "HOST", "password": "PASSWORD"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("x-env = %v, want %v", env, want)
+	}
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// fileKeySuffix marks keys whose value is the path of a file holding the actual value, e.g.
+// DB_PASSWORD_FILE=/run/secrets/db_password
+const fileKeySuffix = "_FILE"
+
+// DirectoryProvider is used to update the configuration from a directory holding one file per
+// key, such as the secrets mounted in /run/secrets or a Kubernetes ConfigMap volume. Files are
+// read on every lookup, so a reload picks up their current content.
+type DirectoryProvider struct {
+	// Dir is the directory holding the files
+	Dir string
+}
+
+// Get fetches the content of the file named after the specified key. An empty string
+// is returned if not found.
+func (d *DirectoryProvider) Get(key string) (string, error) {
+	value, _, err := d.Lookup(key)
+	return value, err
+}
+
+// Lookup fetches the content of the file named after the specified key, or after the key in
+// lower case, and reports whether such a file exists. A trailing newline is removed.
+func (d *DirectoryProvider) Lookup(key string) (string, bool, error) {
+	for _, name := range []string{key, strings.ToLower(key)} {
+		value, err := readValueFile(filepath.Join(d.Dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", false, err
+		}
+		return value, true, nil
+	}
+	return "", false, nil
+}
+
+// Name identifies the provider in errors
+func (d *DirectoryProvider) Name() string {
+	return "directory " + d.Dir
+}
+
+// watchedFiles returns the files of the directory, so that the watcher reloads the
+// configuration when one of them changes
+func (d *DirectoryProvider) watchedFiles() []string {
+	entries, err := ioutil.ReadDir(d.Dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, filepath.Join(d.Dir, entry.Name()))
+	}
+	return files
+}
+
+// readValueFile returns the content of a file holding a single value, without the trailing
+// newline
+func readValueFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
+
+// maskedValue replaces the value of secrets in JSON, logs and provenance
+const maskedValue = "******"
+
+// Secret is a string that is masked as ****** when it is marshaled to JSON or formatted with
+// fmt, so that credentials do not leak through logs and configuration dumps. Its value is
+// only available through Reveal. A string field with a `secret:"true"` tag is masked the same
+// way in the dumps of the package, but not when it is formatted directly.
+type Secret string
+
+// Reveal returns the value of the secret
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String returns the masked value
+func (s Secret) String() string {
+	return maskedValue
+}
+
+// Format writes the masked value for all verbs, including %v, %#v and %s
+func (s Secret) Format(f fmt.State, verb rune) {
+	if verb == 'q' {
+		fmt.Fprintf(f, "%q", maskedValue)
+		return
+	}
+	io.WriteString(f, maskedValue)
+}
+
+// MarshalJSON returns the masked value as a JSON string
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(maskedValue)
+}
+
+var secretType = reflect.TypeOf(Secret(""))
+
+// isSecret reports whether the field holds a Secret or has a secret:"true" tag
+func isSecret(ft reflect.StructField) bool {
+	t := ft.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == secretType || ft.Tag.Get("secret") == "true"
+}
+
+// secretPaths adds the dotted json paths of the secret fields of the struct type t to paths
+func secretPaths(t reflect.Type, path string, maxDepth int, paths map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		fieldPath := fieldPath(path, ft)
+		if isSecret(ft) {
+			paths[fieldPath] = true
+			continue
+		}
+		ftype := ft.Type
+		for ftype.Kind() == reflect.Ptr {
+			ftype = ftype.Elem()
+		}
+		if maxDepth > 0 && ftype.Kind() == reflect.Struct && !isLeaf(ftype) {
+			secretPaths(ftype, fieldPath, maxDepth-1, paths)
+		}
+	}
+}
+
+// maskedJSON returns the JSON encoding of the structure s points to with the values of all
+// secret fields masked
+func maskedJSON(s interface{}) ([]byte, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]bool)
+	secretPaths(reflect.TypeOf(s).Elem(), "", 4, paths)
+	if len(paths) == 0 {
+		return b, nil
+	}
+	var tree interface{}
+	if err := json.Unmarshal(b, &tree); err != nil {
+		return nil, err
+	}
+	for path := range paths {
+		maskPath(tree, strings.Split(path, "."))
+	}
+	return json.Marshal(tree)
+}
+
+// maskPath replaces the value at the path of keys in a decoded JSON tree, if present
+func maskPath(tree interface{}, keys []string) {
+	obj, ok := tree.(map[string]interface{})
+	if !ok {
+		return
+	}
+	child, ok := obj[keys[0]]
+	if !ok {
+		return
+	}
+	if len(keys) == 1 {
+		if child != nil {
+			obj[keys[0]] = maskedValue
+		}
+		return
+	}
+	maskPath(child, keys[1:])
+}
+
+// mask replaces the values of a provenance entry of a secret field
+func (p *Provenance) mask() {
+	p.Source.Value = maskedValue
+	shadowed := make([]Source, len(p.Shadowed))
+	for i, s := range p.Shadowed {
+		shadowed[i] = Source{Name: s.Name, Value: maskedValue}
+	}
+	p.Shadowed = shadowed
+}
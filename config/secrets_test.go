@@ -0,0 +1,205 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type fileValueConfig struct {
+	Password Secret `json:"password" env:"STEST_PASSWORD"`
+	User     string `json:"user" env:"STEST_USER" default:"guest"`
+	Region   string `json:"region" env:"STEST_REGION" default:"eu"`
+}
+
+// TestFileKeys reads the values of keys with a _FILE suffix from the files they name
+func TestFileKeys(t *testing.T) {
+	dir := t.TempDir()
+	password := filepath.Join(dir, "password")
+	writeFile(t, password, "s3cret\r\n")
+	user := filepath.Join(dir, "user")
+	writeFile(t, user, "from file\n")
+	t.Setenv("STEST_PASSWORD_FILE", password)
+	t.Setenv("STEST_USER_FILE", user)
+	t.Setenv("STEST_USER", "from env")
+
+	l := NewLoader(ConfigFiles())
+	var conf fileValueConfig
+	if err := l.Load(&conf); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if conf.Password.Reveal() != "s3cret" || conf.User != "from env" || conf.Region != "eu" {
+		t.Errorf("Load() = %q, %q, %q, want the file content without its newline and the key over its _FILE key",
+			conf.Password.Reveal(), conf.User, conf.Region)
+	}
+	want := "environment (STEST_PASSWORD_FILE=" + password + ")"
+	if p, ok := l.ExplainFor(&conf, "password"); !ok || p.Source.Name != want {
+		t.Errorf("ExplainFor(password) = %v, want %s as source", p, want)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	t.Setenv("STEST_PASSWORD_FILE", missing)
+	err := l.Load(&fileValueConfig{})
+	var errs FieldErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "password" || !os.IsNotExist(errors.Unwrap(errs[0])) {
+		t.Fatalf("Load() with a missing file error = %v, want a field error of password", err)
+	}
+	if !strings.Contains(errs[0].Provider, "STEST_PASSWORD_FILE="+missing) {
+		t.Errorf("provider = %s, want the _FILE key", errs[0].Provider)
+	}
+}
+
+func TestDirectoryProvider(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "STEST_PASSWORD"), "s3cret\n")
+	writeFile(t, filepath.Join(dir, "stest_user"), "admin")
+	writeFile(t, filepath.Join(dir, "STEST_REGION"), "")
+	writeFile(t, filepath.Join(dir, ".hidden"), "x")
+	if err := os.Mkdir(filepath.Join(dir, "STEST_DIR"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &DirectoryProvider{Dir: dir}
+	tests := []struct {
+		key   string
+		value string
+		found bool
+	}{
+		{"STEST_PASSWORD", "s3cret", true},
+		{"STEST_USER", "admin", true},
+		{"STEST_REGION", "", true},
+		{"STEST_MISSING", "", false},
+	}
+	for _, tt := range tests {
+		value, found, err := d.Lookup(tt.key)
+		if value != tt.value || found != tt.found || err != nil {
+			t.Errorf("Lookup(%s) = %q, %v, %v, want %q, %v", tt.key, value, found, err, tt.value, tt.found)
+		}
+	}
+	if _, _, err := d.Lookup("STEST_DIR"); err == nil {
+		t.Error("Lookup() of a directory succeeded, want an error")
+	}
+
+	files := d.watchedFiles()
+	sort.Strings(files)
+	var want []string
+	for _, name := range []string{"STEST_DIR", "STEST_PASSWORD", "STEST_REGION", "stest_user"} {
+		want = append(want, filepath.Join(dir, name))
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("watchedFiles() = %v, want %v", files, want)
+	}
+
+	// the files override the defaults and an empty file resets the field
+	t.Setenv("STEST_USER", "from env")
+	l := NewLoader(ConfigFiles())
+	l.AddDataProvider(d)
+	var conf fileValueConfig
+	if err := l.Load(&conf); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if conf.Password.Reveal() != "s3cret" || conf.User != "from env" || conf.Region != "" {
+		t.Errorf("Load() = %q, %q, %q, want the values of the directory under the environment",
+			conf.Password.Reveal(), conf.User, conf.Region)
+	}
+	if p, ok := l.ExplainFor(&conf, "password"); !ok || p.Source.Name != "directory "+dir {
+		t.Errorf("ExplainFor(password) = %v, want the directory as source", p)
+	}
+}
+
+type maskedConfig struct {
+	User     string  `json:"user"`
+	Password Secret  `json:"password"`
+	Token    *Secret `json:"token"`
+	APIKey   string  `json:"api_key" secret:"true"`
+	Empty    *Secret `json:"empty"`
+}
+
+func TestSecretMasking(t *testing.T) {
+	s := Secret("s3cret")
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"String", s.String(), "******"},
+		{"Reveal", s.Reveal(), "s3cret"},
+		{"%v", fmt.Sprintf("%v", s), "******"},
+		{"%s", fmt.Sprintf("%s", s), "******"},
+		{"%#v", fmt.Sprintf("%#v", s), "******"},
+		{"%q", fmt.Sprintf("%q", s), `"******"`},
+		{"%x", fmt.Sprintf("%x", s), "******"},
+		{"%10s", fmt.Sprintf("%10s", s), "******"},
+		{"Sprint", fmt.Sprint(s), "******"},
+		{"struct %+v", fmt.Sprintf("%+v", struct{ Password Secret }{s}), "{Password:******}"},
+		{"struct %#v", fmt.Sprintf("%#v", struct{ Password Secret }{s}), "struct { Password config.Secret }{Password:******}"},
+		{"slice", fmt.Sprint([]Secret{s, ""}), "[****** ******]"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil || string(b) != `"******"` {
+		t.Errorf("json.Marshal() = %s, %v, want the masked value", b, err)
+	}
+	token := Secret("abc")
+	conf := maskedConfig{User: "admin", Password: s, Token: &token, APIKey: "key"}
+	b, err = json.Marshal(conf)
+	if want := `{"user":"admin","password":"******","token":"******","api_key":"key","empty":null}`; err != nil || string(b) != want {
+		t.Errorf("json.Marshal() = %s, %v, want %s", b, err, want)
+	}
+	b, err = maskedJSON(&conf)
+	if want := `{"api_key":"******","empty":null,"password":"******","token":"******","user":"admin"}`; err != nil || string(b) != want {
+		t.Errorf("maskedJSON() = %s, %v, want %s", b, err, want)
+	}
+}
+
+type secretSourcesConfig struct {
+	Password Secret `json:"password" env:"STEST_PASSWORD" default:"changeme"`
+	Token    string `json:"token" env:"STEST_TOKEN" secret:"true"`
+	User     string `json:"user" env:"STEST_USER"`
+}
+
+// TestSecretProvenance lists the sources of secret fields with their values masked
+func TestSecretProvenance(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	writeFile(t, file, `{"password":"from file","token":"abc","user":"admin"}`)
+	t.Setenv("STEST_PASSWORD", "from env")
+
+	l := NewLoader(ConfigFiles(file))
+	var conf secretSourcesConfig
+	if err := l.Load(&conf); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if conf.Password.Reveal() != "from env" || conf.Token != "abc" {
+		t.Fatalf("Load() = %q, %q, want the values unmasked", conf.Password.Reveal(), conf.Token)
+	}
+	want := map[string]Provenance{
+		"password": {Path: "password", Key: "STEST_PASSWORD", Source: Source{"environment", "******"},
+			Shadowed: []Source{{file, "******"}, {defaultTagSource, "******"}}},
+		"token": {Path: "token", Key: "STEST_TOKEN", Source: Source{file, "******"}},
+		"user":  {Path: "user", Key: "STEST_USER", Source: Source{file, "admin"}},
+	}
+	sources := l.SourcesFor(&conf)
+	if len(sources) != len(want) {
+		t.Fatalf("SourcesFor() = %+v, want %d fields", sources, len(want))
+	}
+	for _, p := range sources {
+		if w := want[p.Path]; fmt.Sprint(p) != fmt.Sprint(w) {
+			t.Errorf("SourcesFor() %s = %+v, want %+v", p.Path, p, w)
+		}
+		explained, ok := l.ExplainFor(&conf, p.Path)
+		if !ok || fmt.Sprint(explained) != fmt.Sprint(p) {
+			t.Errorf("ExplainFor(%s) = %+v, want %+v", p.Path, explained, p)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"grail/sysinfra/cfg/log"
+)
+
+// Strict is a functional argument you can pass to Init() to make it fail when a config file
+// cannot be read or contains keys that do not match a field of the configuration. Without it,
+// such files are skipped with an error message and unknown keys are logged as warnings.
+// Missing files are never an error.
+func Strict() func(*initOptions) {
+	return func(o *initOptions) {
+		o.Strict = true
+	}
+}
+
+// UnknownKeyError describes a key of a config file that does not match any field
+type UnknownKeyError struct {
+	// File is the path of the config file
+	File string
+	// Path is the dotted path of the key in the file, e.g. build.vesion
+	Path string
+	// Line and Column locate the key in JSON and YAML files, starting at 1. They are 0 for
+	// other formats.
+	Line   int
+	Column int
+}
+
+func (e *UnknownKeyError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: unknown key %s", e.File, e.Path)
+	}
+	return fmt.Sprintf("%s:%d:%d: unknown key %s", e.File, e.Line, e.Column, e.Path)
+}
+
+// UnknownKeyErrors is the error returned in strict mode for a config file with unknown keys. It
+// contains one entry for every unknown key.
+type UnknownKeyErrors []*UnknownKeyError
+
+func (e UnknownKeyErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d unknown key(s): %s", len(e), strings.Join(msgs, "; "))
+}
+
+// checkUnknownKeys reports the keys of the tree read from a config file that do not match a
+// field of conf. They are returned as UnknownKeyErrors in strict mode and logged otherwise.
+// data is the content of the file, used to locate the keys of JSON and YAML files.
+func checkUnknownKeys(filePath string, format string, data []byte, tree interface{}, conf interface{},
+	strict bool) error {
+	var paths []string
+	unknownKeys(reflect.TypeOf(conf), tree, "", &paths)
+	if len(paths) == 0 {
+		return nil
+	}
+	positions := keyPositions(format, data)
+	var errs UnknownKeyErrors
+	for _, path := range paths {
+		err := &UnknownKeyError{File: filePath, Path: path}
+		if pos, ok := positions[path]; ok {
+			err.Line, err.Column = pos.line, pos.column
+		}
+		errs = append(errs, err)
+	}
+	// in the order of the file
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		if errs[i].Column != errs[j].Column {
+			return errs[i].Column < errs[j].Column
+		}
+		return errs[i].Path < errs[j].Path
+	})
+	if strict {
+		return errs
+	}
+	for _, err := range errs {
+		log.Warnf("%v", err)
+	}
+	return nil
+}
+
+// unknownKeys adds the paths of the keys of data that do not match a field of type t to paths.
+// Keys are matched with the json names of fields ignoring case, as encoding/json does.
+func unknownKeys(t reflect.Type, data interface{}, path string, paths *[]string) {
+	t = indirectType(t)
+	if isJSONLeaf(t) {
+		// decoded as a whole by UnmarshalJSON
+		return
+	}
+	switch val := data.(type) {
+	case map[string]interface{}:
+		switch {
+		case t.Kind() == reflect.Map:
+			for key, child := range val {
+				unknownKeys(t.Elem(), child, joinPath(path, key), paths)
+			}
+		case t.Kind() == reflect.Struct && !isLeaf(t):
+			fields := jsonFields(t)
+			for key, child := range val {
+				index, ok := fields[strings.ToLower(key)]
+				if !ok {
+					*paths = append(*paths, joinPath(path, key))
+					continue
+				}
+				unknownKeys(t.FieldByIndex(index).Type, child, joinPath(path, key), paths)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, child := range val {
+				unknownKeys(t.Elem(), child, fmt.Sprintf("%s[%d]", path, i), paths)
+			}
+		}
+	}
+}
+
+// keyPosition locates a key in a config file, starting at line 1 and column 1
+type keyPosition struct {
+	line, column int
+}
+
+// keyPositions returns the position of every object key of a JSON or YAML document, by dotted
+// path. The keys of other formats are not located.
+func keyPositions(format string, data []byte) map[string]keyPosition {
+	switch format {
+	case FormatJSON:
+		positions := make(map[string]keyPosition)
+		for path, offset := range jsonKeyOffsets(data) {
+			line, column := lineColumn(data, offset)
+			positions[path] = keyPosition{line, column}
+		}
+		return positions
+	case FormatYAML:
+		return yamlKeyPositions(data)
+	}
+	return nil
+}
+
+// jsonKeyOffsets returns the offset of the opening quote of every object key of a json
+// document, by dotted path
+func jsonKeyOffsets(data []byte) map[string]int64 {
+	type container struct {
+		path    string
+		isArray bool
+		index   int
+		// key is the key whose value is expected next in an object
+		key string
+	}
+	offsets := make(map[string]int64)
+	d := json.NewDecoder(bytes.NewReader(data))
+	var stack []*container
+	// childPath returns the path of the next value in the innermost container
+	childPath := func() string {
+		if len(stack) == 0 {
+			return ""
+		}
+		c := stack[len(stack)-1]
+		if c.isArray {
+			return fmt.Sprintf("%s[%d]", c.path, c.index)
+		}
+		return joinPath(c.path, c.key)
+	}
+	// valueDone moves the innermost container past a value
+	valueDone := func() {
+		if len(stack) == 0 {
+			return
+		}
+		c := stack[len(stack)-1]
+		if c.isArray {
+			c.index++
+		} else {
+			c.key = ""
+		}
+	}
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return offsets
+		}
+		switch tok := token.(type) {
+		case json.Delim:
+			switch tok {
+			case '{', '[':
+				stack = append(stack, &container{path: childPath(), isArray: tok == '['})
+			default:
+				stack = stack[:len(stack)-1]
+				valueDone()
+			}
+		case string:
+			if c := stack[len(stack)-1]; !c.isArray && c.key == "" {
+				c.key = tok
+				end := d.InputOffset()
+				offsets[joinPath(c.path, tok)] = int64(bytes.LastIndexByte(data[:end-1], '"'))
+				continue
+			}
+			valueDone()
+		default:
+			valueDone()
+		}
+	}
+}
+
+// lineColumn converts an offset in data to a line and a column, starting at 1
+func lineColumn(data []byte, offset int64) (line int, column int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - (bytes.LastIndexByte(before, '\n') + 1) + 1
+	return line, column
+}
+
+// jsonError adds the file name and, when data is not nil, the line and column to the errors
+// of encoding/json
+func jsonError(filePath string, data []byte, err error) error {
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		offset = int64(len(data))
+	}
+	if data == nil || offset < 0 {
+		return fmt.Errorf("%s: %w", filePath, err)
+	}
+	line, column := lineColumn(data, offset)
+	return fmt.Errorf("%s:%d:%d: %w", filePath, line, column, err)
+}
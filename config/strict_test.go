@@ -0,0 +1,58 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type strictServer struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type strictConfig struct {
+	Name     string            `json:"name"`
+	Server   strictServer      `json:"server"`
+	Backends []strictServer    `json:"backends"`
+	Labels   map[string]string `json:"labels"`
+}
+
+// TestUnknownKeys locates the unknown keys of JSON and YAML files
+func TestUnknownKeys(t *testing.T) {
+	tests := []struct {
+		file    string
+		content string
+		want    []string
+	}{
+		{"config.json", "{\n  \"name\": \"api\",\n  \"nmae\": \"x\",\n  \"server\": {\"host\": \"h\", \"prot\": 1},\n" +
+			"  \"backends\": [{\"host\": \"b\"}, {\"hots\": \"c\"}],\n  \"labels\": {\"any\": \"key\"}\n}\n",
+			[]string{"3:3: unknown key nmae", "4:27: unknown key server.prot", "5:32: unknown key backends[1].hots"}},
+		{"config.yaml", "name: api\nnmae: x\nserver:\n  host: h\n  \"prot\": 1\nbackends:\n  - host: b\n  - hots: c\n" +
+			"labels:\n  any: key\n",
+			[]string{"2:1: unknown key nmae", "5:3: unknown key server.prot", "8:5: unknown key backends[1].hots"}},
+		{"anchors.yaml", "base: &base\n  host: h\n  prot: 1\nserver:\n  <<: *base\n  port: 2\nbackends:\n  - *base\n",
+			[]string{"1:1: unknown key base", "3:3: unknown key backends[0].prot", "3:3: unknown key server.prot"}},
+		{"config.toml", "name = \"api\"\nnmae = \"x\"\n[server]\nprot = 1\n",
+			[]string{"unknown key nmae", "unknown key server.prot"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), tt.file)
+			writeFile(t, file, tt.content)
+			err := NewLoader(ConfigFiles(file), Strict()).Load(&strictConfig{})
+			var errs UnknownKeyErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Load() error = %v, want UnknownKeyErrors", err)
+			}
+			var got []string
+			for _, e := range errs {
+				got = append(got, strings.TrimLeft(strings.TrimPrefix(e.Error(), file), ": "))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Load() unknown keys =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
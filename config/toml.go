@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseTOML parses a TOML document with github.com/BurntSushi/toml. Numbers, booleans and dates
+// are returned as text: integers in decimal, floats in the shortest form that reads back
+// exactly and dates in RFC 3339 format.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return nil, err
+	}
+	root, _ := tomlValue(doc).(map[string]interface{})
+	if root == nil {
+		root = map[string]interface{}{}
+	}
+	return root, nil
+}
+
+// tomlValue converts a value decoded by the toml package to the tree returned by parseTOML
+func tomlValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(val))
+		for key, child := range val {
+			obj[key] = tomlValue(child)
+		}
+		return obj
+	case []map[string]interface{}:
+		list := make([]interface{}, len(val))
+		for i, child := range val {
+			list[i] = tomlValue(child)
+		}
+		return list
+	case []interface{}:
+		list := make([]interface{}, len(val))
+		for i, child := range val {
+			list[i] = tomlValue(child)
+		}
+		return list
+	case string:
+		return val
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case time.Time:
+		// the toml package marks local dates and times with these zone names
+		switch val.Location().String() {
+		case "date-local":
+			return val.Format("2006-01-02")
+		case "time-local":
+			return val.Format("15:04:05.999999999")
+		case "datetime-local":
+			return val.Format("2006-01-02T15:04:05.999999999")
+		}
+		return val.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  map[string]interface{}{},
+		},
+		{
+			name:  "scalars as text",
+			input: "port = 9_000\nmask = 0xff\ntimeout = \"30s\"\nenabled = true\nratio = 0.5\n",
+			want: map[string]interface{}{"port": "9000", "mask": "255", "timeout": "30s",
+				"enabled": "true", "ratio": "0.5"},
+		},
+		{
+			name: "dates",
+			input: "odt = 1979-05-27T07:32:00Z\nldt = 1979-05-27T07:32:00.5\n" +
+				"ld = 1979-05-27\nlt = 07:32:00\n",
+			want: map[string]interface{}{"odt": "1979-05-27T07:32:00Z", "ldt": "1979-05-27T07:32:00.5",
+				"ld": "1979-05-27", "lt": "07:32:00"},
+		},
+		{
+			name:  "strings",
+			input: "basic = \"a\\tb\"\nliteral = 'c:\\dir'\nmulti = \"\"\"\nx\ny\"\"\"\n",
+			want:  map[string]interface{}{"basic": "a\tb", "literal": `c:\dir`, "multi": "x\ny"},
+		},
+		{
+			name:  "tables and dotted keys",
+			input: "name = \"svc\"\nbuild.commit = \"abc\"\n[build]\nversion = \"2.0\"\n[limits]\ncpu = 1\n",
+			want: map[string]interface{}{
+				"name":   "svc",
+				"build":  map[string]interface{}{"version": "2.0", "commit": "abc"},
+				"limits": map[string]interface{}{"cpu": "1"},
+			},
+		},
+		{
+			name:  "arrays, inline tables and arrays of tables",
+			input: "hosts = [\"a\", \"b\"]\npoint = {x = 1, y = 2}\n[[users]]\nname = \"x\"\n[[users]]\nname = \"y\"\n",
+			want: map[string]interface{}{
+				"hosts": []interface{}{"a", "b"},
+				"point": map[string]interface{}{"x": "1", "y": "2"},
+				"users": []interface{}{map[string]interface{}{"name": "x"}, map[string]interface{}{"name": "y"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("parseTOML() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "duplicate table", input: "[a]\nx = 1\n[a]\ny = 2\n", want: "line 3"},
+		{name: "duplicate key", input: "a = 1\na = 2\n", want: "line 2"},
+		{name: "unterminated string", input: "a = \"x\n", want: "line 1"},
+		{name: "missing value", input: "a =\n", want: "line 1"},
+		{name: "bare value", input: "a = b\n", want: "line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTOML([]byte(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseTOML() error = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
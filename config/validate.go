@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError describes a field whose value violates a rule of its validate tag
+type ValidationError struct {
+	// Path is the dotted json path of the field, e.g. build.version
+	Path string
+	// Key is the environment variable style key of the field, if it has one
+	Key string
+	// Rule is the violated rule, e.g. max=65535
+	Rule string
+	// Value is the offending value
+	Value string
+	// Message explains the violation
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s: %s, got %q", e.Path, e.Message, e.Value)
+	}
+	return fmt.Sprintf("%s (%s): %s, got %q", e.Path, e.Key, e.Message, e.Value)
+}
+
+// ValidationErrors is the aggregated error returned by Validate. It contains one entry for
+// every violated rule.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d validation error(s): %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Validate checks the fields of the structure s points to against their validate tags and
+// returns all the violations as ValidationErrors. Rules are separated by commas:
+//   - required: the value must not be the zero value
+//   - min=n, max=n: bounds of numbers, or of the length of strings, slices and maps.
+//     Durations use duration bounds, e.g. max=1m
+//   - oneof=a b c: the value must be one of the space separated values
+//   - oneofci=a b c: oneof ignoring case
+//   - pattern=re: the value must match the regular expression. As the expression may contain
+//     commas, pattern must be the last rule of the tag.
+//
+// For example:
+//
+//	Port int `json:"port" env:"PORT" validate:"required,min=1,max=65535"`
+func Validate(s interface{}) error {
+	var errs ValidationErrors
+	validateStruct(reflect.ValueOf(s).Elem(), "", 4, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, path string, maxDepth int, errs *ValidationErrors) {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		fv := v.Field(i)
+		ft := t.Field(i)
+		fieldPath := fieldPath(path, ft)
+		if tag := ft.Tag.Get("validate"); tag != "" && fv.CanInterface() {
+			for _, rule := range splitRules(tag) {
+				if msg := checkRule(fv, rule); msg != "" {
+					value := displayValue(fv)
+					if isSecret(ft) {
+						value = maskedValue
+					}
+					*errs = append(*errs, &ValidationError{Path: fieldPath, Key: ft.Tag.Get("env"),
+						Rule: rule, Value: value, Message: msg})
+				}
+			}
+		}
+		if maxDepth > 0 && isStruct(fv, ft) {
+			validateStruct(fv, fieldPath, maxDepth-1, errs)
+		}
+	}
+}
+
+// displayValue formats the value of field for errors, following pointers
+func displayValue(field reflect.Value) string {
+	for field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return "<nil>"
+		}
+		field = field.Elem()
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// splitRules splits a validate tag into rules. Everything after pattern= is the pattern.
+func splitRules(tag string) []string {
+	var rules []string
+	for tag != "" {
+		if strings.HasPrefix(tag, "pattern=") {
+			return append(rules, tag)
+		}
+		rule := tag
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			rule, tag = tag[:i], tag[i+1:]
+		} else {
+			tag = ""
+		}
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// checkRule returns a message describing why the value of field violates rule, or "" if it
+// does not
+func checkRule(field reflect.Value, rule string) string {
+	name, param := rule, ""
+	if i := strings.IndexByte(rule, '='); i >= 0 {
+		name, param = rule[:i], rule[i+1:]
+	}
+	for field.Kind() == reflect.Ptr && name != "required" {
+		if field.IsNil() {
+			// optional values are only checked when set
+			return ""
+		}
+		field = field.Elem()
+	}
+
+	switch name {
+	case "required":
+		if field.IsZero() {
+			return "is required"
+		}
+	case "min", "max":
+		value, bound, err := compareValues(field, param)
+		if err != nil {
+			return fmt.Sprintf("invalid rule %s: %v", rule, err)
+		}
+		if name == "min" && value < bound {
+			return fmt.Sprintf("must be at least %s", param)
+		}
+		if name == "max" && value > bound {
+			return fmt.Sprintf("must be at most %s", param)
+		}
+	case "oneof", "oneofci":
+		value := ruleValue(field)
+		for _, allowed := range strings.Fields(param) {
+			if value == allowed || name == "oneofci" && strings.EqualFold(value, allowed) {
+				return ""
+			}
+		}
+		if name == "oneofci" {
+			return fmt.Sprintf("must be one of %s, in any case", param)
+		}
+		return fmt.Sprintf("must be one of %s", param)
+	case "pattern":
+		re, err := regexp.Compile(param)
+		if err != nil {
+			return fmt.Sprintf("invalid rule %s: %v", rule, err)
+		}
+		if !re.MatchString(ruleValue(field)) {
+			return fmt.Sprintf("must match %s", param)
+		}
+	default:
+		return fmt.Sprintf("unknown validation rule %s", rule)
+	}
+	return ""
+}
+
+// ruleValue returns the value of field as text, using the underlying string of string types
+// such as Secret
+func ruleValue(field reflect.Value) string {
+	if field.Kind() == reflect.String {
+		return field.String()
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// compareValues returns the value of field and the bound param as comparable numbers: the
+// number itself, the length of strings, slices and maps, or the duration.
+func compareValues(field reflect.Value, param string) (value float64, bound float64, err error) {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(param)
+		return float64(field.Int()), float64(d), err
+	}
+	bound, err = strconv.ParseFloat(param, 64)
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value = float64(field.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value = float64(field.Uint())
+	case reflect.Float32, reflect.Float64:
+		value = field.Float()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		value = float64(field.Len())
+	default:
+		err = fmt.Errorf("cannot compare %s", field.Type())
+	}
+	return value, bound, err
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckRuleOneOf(t *testing.T) {
+	tests := []struct {
+		rule  string
+		value string
+		want  string
+	}{
+		{"oneof=text json", "json", ""},
+		{"oneof=text json", "JSON", "must be one of text json"},
+		{"oneofci=DEBUG INFO", "debug", ""},
+		{"oneofci=DEBUG INFO", "Info", ""},
+		{"oneofci=DEBUG INFO", "INFO", ""},
+		{"oneofci=DEBUG INFO", "verbose", "must be one of DEBUG INFO, in any case"},
+		{"oneofci=DEBUG INFO", "", "must be one of DEBUG INFO, in any case"},
+	}
+	for _, tt := range tests {
+		conf := struct{ Value string }{tt.value}
+		field := reflect.ValueOf(&conf).Elem().Field(0)
+		if got := checkRule(field, tt.rule); got != tt.want {
+			t.Errorf("checkRule(%q, %q) = %q, want %q", tt.value, tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestLogLevelValidation(t *testing.T) {
+	for _, level := range []string{"debug", "Warn", "ERROR", "panic", "FATAL"} {
+		conf, err := NewLoader(ConfigFiles()).Init(Defaults(Set(LOG_LEVEL, level)))
+		if err != nil {
+			t.Errorf("Init() with log level %s error = %v", level, err)
+		} else if conf.LogLevel != level {
+			t.Errorf("log level = %q, want %q", conf.LogLevel, level)
+		}
+	}
+
+	_, err := NewLoader(ConfigFiles()).Init(Defaults(Set(LOG_LEVEL, "garbage"), Set(LOG_FORMAT, "xml")))
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("Init() error = %v, want ValidationErrors", err)
+	}
+	var paths []string
+	for _, verr := range verrs {
+		paths = append(paths, verr.Path)
+	}
+	if got := strings.Join(paths, " "); got != "log_level log_format" {
+		t.Errorf("invalid fields = %s, want both log_level and log_format", got)
+	}
+}
+
+func TestLogLevelSchemaEnum(t *testing.T) {
+	b, err := Schema(&Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema jsonSchema
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprint(schema.Properties["log_level"].Enum)
+	if want := "[DEBUG debug INFO info WARN warn ERROR error PANIC panic FATAL fatal]"; got != want {
+		t.Errorf("log_level enum = %s, want %s", got, want)
+	}
+}
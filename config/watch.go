@@ -0,0 +1,202 @@
+package config
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"grail/sysinfra/cfg/log"
+)
+
+// Watch is a functional argument you can pass to Init() to reload the configuration when one
+// of the config files, the files named by _FILE keys or the files of a DirectoryProvider
+// changes. The files are checked every interval and a SIGHUP triggers an
+// immediate reload. For example:
+//
+//	Init(Watch(10 * time.Second))
+func Watch(interval time.Duration) func(*initOptions) {
+	return func(o *initOptions) {
+		o.WatchInterval = interval
+	}
+}
+
+// OnChange registers a function that is called after every successful reload with the
+// previous and the new configuration.
+func OnChange(listener func(old, new *Configuration)) {
+	defaultLoader.OnChange(listener)
+}
+
+// Reload reads the config files and the data providers again, with the options passed to
+// Init, into a new Configuration. If it is valid and differs from the current configuration it
+// replaces it, the log level is updated and the OnChange listeners are notified. Otherwise the
+// current configuration is kept and any error is returned.
+func Reload() error {
+	return defaultLoader.Reload()
+}
+
+// StopWatching stops the watcher started by the Watch option
+func StopWatching() {
+	defaultLoader.StopWatching()
+}
+
+// OnChange registers a function that is called after every successful reload of the Loader
+// with the previous and the new configuration.
+func (l *Loader) OnChange(listener func(old, new *Configuration)) {
+	l.listenersLock.Lock()
+	defer l.listenersLock.Unlock()
+	l.listeners = append(l.listeners, listener)
+}
+
+// Reload loads the configuration of the Loader again. See the package level Reload.
+func (l *Loader) Reload() error {
+	l.reloadLock.Lock()
+	options := l.reloadOptions
+	l.reloadLock.Unlock()
+	return l.reload(options)
+}
+
+func (l *Loader) reload(options []func(*initOptions)) error {
+	l.swapLock.Lock()
+	conf, level, err := l.newConfiguration(options)
+	if err != nil {
+		l.swapLock.Unlock()
+		return err
+	}
+	old := l.Config()
+	if reflect.DeepEqual(old, conf) {
+		l.swapLock.Unlock()
+		return nil
+	}
+	l.current.Store(conf)
+	l.swapLock.Unlock()
+	l.setLogging(conf, level)
+	log.Infof("Configuration reloaded")
+	l.notify(old, conf)
+	return nil
+}
+
+// notify calls the OnChange listeners
+func (l *Loader) notify(old, conf *Configuration) {
+	l.listenersLock.Lock()
+	listeners := make([]func(old, new *Configuration), len(l.listeners))
+	copy(listeners, l.listeners)
+	l.listenersLock.Unlock()
+	for _, listener := range listeners {
+		listener(old, conf)
+	}
+}
+
+// StopWatching stops the watcher started by the Watch option of the Loader
+func (l *Loader) StopWatching() {
+	l.reloadLock.Lock()
+	defer l.reloadLock.Unlock()
+	if l.stopWatch != nil {
+		close(l.stopWatch)
+		l.stopWatch = nil
+	}
+}
+
+// filesSnapshot is the fingerprint of the watched files and the stamps it was computed from
+type filesSnapshot struct {
+	stamps      map[string]fileStamp
+	fingerprint string
+}
+
+// snapshotFiles returns the fingerprint of the files watched with the options, or nil if they
+// do not include Watch. Init takes it before loading, so that a file changed while the
+// configuration is loaded triggers a reload.
+func (l *Loader) snapshotFiles(options []func(*initOptions)) *filesSnapshot {
+	ops := l.initOptions(options)
+	if ops.WatchInterval <= 0 {
+		return nil
+	}
+	stamps := make(map[string]fileStamp)
+	return &filesSnapshot{stamps: stamps,
+		fingerprint: fingerprint(append(configFiles(&ops), l.watchedFiles()...), stamps)}
+}
+
+// startWatching remembers the options for Reload and starts a watcher if they include Watch.
+// The watcher reloads when the files differ from the snapshot taken by snapshotFiles. A watcher
+// started by a previous Init is stopped.
+func (l *Loader) startWatching(options []func(*initOptions), snapshot *filesSnapshot) {
+	l.StopWatching()
+	ops := l.initOptions(options)
+
+	l.reloadLock.Lock()
+	defer l.reloadLock.Unlock()
+	l.reloadOptions = options
+	if ops.WatchInterval <= 0 || snapshot == nil {
+		return
+	}
+	l.stopWatch = make(chan struct{})
+	go l.watch(ops, options, snapshot, l.stopWatch)
+}
+
+// watch reloads the configuration when the fingerprint of the config files changes or a
+// SIGHUP is received, until stop is closed
+func (l *Loader) watch(ops initOptions, options []func(*initOptions), snapshot *filesSnapshot,
+	stop chan struct{}) {
+	ticker := time.NewTicker(ops.WatchInterval)
+	defer ticker.Stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	stamps := snapshot.stamps
+	last := snapshot.fingerprint
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			log.Infof("SIGHUP received, reloading configuration")
+		case <-ticker.C:
+			current := fingerprint(append(configFiles(&ops), l.watchedFiles()...), stamps)
+			if current == last {
+				continue
+			}
+			last = current
+			log.Infof("Config files changed, reloading configuration")
+		}
+		if err := l.reload(options); err != nil {
+			log.Errorf("keeping previous configuration, reload failed: %v", err)
+		}
+	}
+}
+
+// fileStamp identifies the content of a config file. The hash is only computed again when
+// the modification time or the size of the file change.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+// fingerprint returns a value that changes whenever the list of config files or the content
+// of one of them changes
+func fingerprint(files []string, stamps map[string]fileStamp) string {
+	h := sha256.New()
+	for _, file := range files {
+		stat, err := os.Stat(file)
+		if err != nil || stat.IsDir() {
+			delete(stamps, file)
+			continue
+		}
+		stamp, ok := stamps[file]
+		if !ok || !stamp.modTime.Equal(stat.ModTime()) || stamp.size != stat.Size() {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			stamp = fileStamp{modTime: stat.ModTime(), size: stat.Size(), hash: sha256.Sum256(data)}
+			stamps[file] = stamp
+		}
+		h.Write([]byte(file))
+		h.Write(stamp.hash[:])
+	}
+	return string(h.Sum(nil))
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAML parses a YAML document with gopkg.in/yaml.v3. Scalars are returned as their text
+// and null values as nil. Anchors, aliases and merge keys (<<) are resolved. The file must hold
+// a single document whose root is a mapping, and tags other than the standard scalar,
+// sequence and mapping tags are rejected.
+func parseYAML(data []byte) (map[string]interface{}, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return map[string]interface{}{}, nil
+		}
+		return nil, err
+	}
+	var next yaml.Node
+	if err := dec.Decode(&next); err == nil {
+		return nil, fmt.Errorf("yaml: line %d: multiple documents are not supported", next.Line)
+	} else if !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	value, err := yamlValue(&doc)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return map[string]interface{}{}, nil
+	}
+	root, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("yaml: document root must be a mapping")
+	}
+	return root, nil
+}
+
+// yamlValue converts a node to the tree returned by parseYAML
+func yamlValue(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(n.Content[0])
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	case yaml.ScalarNode:
+		switch tag := n.ShortTag(); tag {
+		case "!!null":
+			return nil, nil
+		case "!!str", "!!int", "!!float", "!!bool", "!!timestamp":
+			return n.Value, nil
+		default:
+			return nil, yamlErrorf(n, "unsupported tag %s", tag)
+		}
+	case yaml.SequenceNode:
+		if tag := n.ShortTag(); tag != "!!seq" {
+			return nil, yamlErrorf(n, "unsupported tag %s", tag)
+		}
+		list := make([]interface{}, 0, len(n.Content))
+		for _, item := range n.Content {
+			value, err := yamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case yaml.MappingNode:
+		if tag := n.ShortTag(); tag != "!!map" {
+			return nil, yamlErrorf(n, "unsupported tag %s", tag)
+		}
+		return yamlMapping(n)
+	}
+	return nil, yamlErrorf(n, "unexpected node")
+}
+
+// yamlMapping converts a mapping node. The keys of the mappings merged with << only apply
+// when the mapping does not set them itself, earlier merged mappings taking precedence.
+func yamlMapping(n *yaml.Node) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	var merges []*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		keyNode, valueNode := n.Content[i], n.Content[i+1]
+		if keyNode.Kind == yaml.ScalarNode && keyNode.ShortTag() == "!!merge" {
+			merges = append(merges, valueNode)
+			continue
+		}
+		for keyNode.Kind == yaml.AliasNode {
+			keyNode = keyNode.Alias
+		}
+		if keyNode.Kind != yaml.ScalarNode {
+			return nil, yamlErrorf(keyNode, "keys must be scalars")
+		}
+		if _, dup := obj[keyNode.Value]; dup {
+			return nil, yamlErrorf(keyNode, "duplicate key %q", keyNode.Value)
+		}
+		value, err := yamlValue(valueNode)
+		if err != nil {
+			return nil, err
+		}
+		obj[keyNode.Value] = value
+	}
+
+	for _, merge := range merges {
+		for merge.Kind == yaml.AliasNode {
+			merge = merge.Alias
+		}
+		sources := []*yaml.Node{merge}
+		if merge.Kind == yaml.SequenceNode {
+			sources = merge.Content
+		}
+		for _, source := range sources {
+			value, err := yamlValue(source)
+			if err != nil {
+				return nil, err
+			}
+			mapping, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, yamlErrorf(source, "merge value must be a mapping")
+			}
+			for key, child := range mapping {
+				if _, ok := obj[key]; !ok {
+					obj[key] = child
+				}
+			}
+		}
+	}
+	return obj, nil
+}
+
+func yamlErrorf(n *yaml.Node, format string, args ...interface{}) error {
+	return fmt.Errorf("yaml: line %d: %s", n.Line, fmt.Sprintf(format, args...))
+}
+
+// yamlKeyPositions returns the position of every mapping key of a YAML document, by dotted
+// path. The keys of anchored and merged mappings are located where the mapping is written.
+func yamlKeyPositions(data []byte) map[string]keyPosition {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	positions := make(map[string]keyPosition)
+	addKeyPositions(&doc, "", positions)
+	return positions
+}
+
+// addKeyPositions adds the positions of the keys of n, whose dotted path is path, to positions
+func addKeyPositions(n *yaml.Node, path string, positions map[string]keyPosition) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) > 0 {
+			addKeyPositions(n.Content[0], path, positions)
+		}
+	case yaml.AliasNode:
+		addKeyPositions(n.Alias, path, positions)
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			addKeyPositions(item, fmt.Sprintf("%s[%d]", path, i), positions)
+		}
+	case yaml.MappingNode:
+		var merges []*yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			keyNode, valueNode := n.Content[i], n.Content[i+1]
+			if keyNode.Kind == yaml.ScalarNode && keyNode.ShortTag() == "!!merge" {
+				merges = append(merges, valueNode)
+				continue
+			}
+			position := keyPosition{keyNode.Line, keyNode.Column}
+			for keyNode.Kind == yaml.AliasNode {
+				keyNode = keyNode.Alias
+			}
+			keyPath := joinPath(path, keyNode.Value)
+			positions[keyPath] = position
+			addKeyPositions(valueNode, keyPath, positions)
+		}
+		// as in yamlMapping, merged keys only apply when the mapping does not set them itself
+		for _, merge := range merges {
+			for merge.Kind == yaml.AliasNode {
+				merge = merge.Alias
+			}
+			sources := []*yaml.Node{merge}
+			if merge.Kind == yaml.SequenceNode {
+				sources = merge.Content
+			}
+			for _, source := range sources {
+				merged := make(map[string]keyPosition)
+				addKeyPositions(source, path, merged)
+				for keyPath, position := range merged {
+					if _, ok := positions[keyPath]; !ok {
+						positions[keyPath] = position
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  map[string]interface{}{},
+		},
+		{
+			name:  "scalars as text",
+			input: "port: 9000\ntimeout: 30s\nenabled: true\nratio: 0.5\nzip: 01234\n",
+			want: map[string]interface{}{"port": "9000", "timeout": "30s", "enabled": "true",
+				"ratio": "0.5", "zip": "01234"},
+		},
+		{
+			name:  "null",
+			input: "a: ~\nb: null\nc:\n",
+			want:  map[string]interface{}{"a": nil, "b": nil, "c": nil},
+		},
+		{
+			name:  "standard tag",
+			input: "n: !!str 123\n",
+			want:  map[string]interface{}{"n": "123"},
+		},
+		{
+			name:  "escapes",
+			input: `path: "a\\b\tc\u00e9\x41\_"` + "\nquote: 'it''s'\n",
+			want:  map[string]interface{}{"path": "a\\b\tc\u00e9A\u00a0", "quote": "it's"},
+		},
+		{
+			name:  "nested mappings and sequences",
+			input: "build:\n  version: \"2.0\"\nhosts:\n  - a\n  - b\nusers:\n  - name: x\n    admin: true\n",
+			want: map[string]interface{}{
+				"build": map[string]interface{}{"version": "2.0"},
+				"hosts": []interface{}{"a", "b"},
+				"users": []interface{}{map[string]interface{}{"name": "x", "admin": "true"}},
+			},
+		},
+		{
+			name:  "flow collections over several lines",
+			input: "hosts: [a,\n  b]\nlimits: {cpu: 1,\n  mem: 2Gi}\n",
+			want: map[string]interface{}{
+				"hosts":  []interface{}{"a", "b"},
+				"limits": map[string]interface{}{"cpu": "1", "mem": "2Gi"},
+			},
+		},
+		{
+			name:  "block scalars",
+			input: "literal: |\n  a\n  b\nfolded: >-\n  a\n  b\n",
+			want:  map[string]interface{}{"literal": "a\nb\n", "folded": "a b"},
+		},
+		{
+			name:  "anchors, aliases and merge keys",
+			input: "base: &base\n  host: db\n  port: 5432\nprimary:\n  <<: *base\n  port: 5433\nreplica: *base\n",
+			want: map[string]interface{}{
+				"base":    map[string]interface{}{"host": "db", "port": "5432"},
+				"primary": map[string]interface{}{"host": "db", "port": "5433"},
+				"replica": map[string]interface{}{"host": "db", "port": "5432"},
+			},
+		},
+		{
+			name:  "document markers",
+			input: "---\na: 1\n...\n",
+			want:  map[string]interface{}{"a": "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("parseYAML() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "multiple documents", input: "a: 1\n---\nb: 2\n", want: "multiple documents"},
+		{name: "custom tag", input: "a: !secret x\n", want: "unsupported tag !secret"},
+		{name: "set", input: "a: !!set {x}\n", want: "unsupported tag !!set"},
+		{name: "binary", input: "a: !!binary aGVsbG8=\n", want: "unsupported tag !!binary"},
+		{name: "duplicate key", input: "a: 1\na: 2\n", want: "duplicate key"},
+		{name: "complex key", input: "? [a, b]\n: 1\n", want: "keys must be scalars"},
+		{name: "root sequence", input: "- a\n", want: "root must be a mapping"},
+		{name: "tab indentation", input: "a:\n\tb: 1\n", want: "line 2"},
+		{name: "unknown alias", input: "a: *nope\n", want: "unknown anchor"},
+		{name: "merge scalar", input: "a:\n  <<: x\n", want: "merge value must be a mapping"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML([]byte(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseYAML() error = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Log output formats
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Entry is a single logged message
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	File    string
+	Line    int
+	Message string
+	// Fields holds key/value pairs: keys at even and values at odd indexes
+	Fields []interface{}
+}
+
+// Encoder converts log entries to the bytes written to the output, including the trailing
+// newline. Encode may be called from several goroutines at the same time.
+type Encoder interface {
+	Encode(e *Entry) []byte
+}
+
+// TextEncoder writes the human readable layout: timestamp, padded level, caller and message
+// followed by the fields as key=value
+type TextEncoder struct {
+	// TimestampFormat is the time layout of the timestamp, including its separator
+	TimestampFormat string
+	// CallerFormat is the fmt format of the file name and line number
+	CallerFormat string
+}
+
+// Encode implements Encoder
+func (t *TextEncoder) Encode(e *Entry) []byte {
+	msg := e.Message
+	if len(e.Fields) > 0 {
+		msg += " " + formatFields(e.Fields)
+	}
+	msg = sanitize(msg)
+
+	var b strings.Builder
+	b.WriteString(e.Time.Format(t.TimestampFormat))
+	b.WriteString(e.Level.PaddedString())
+	_, _ = fmt.Fprintf(&b, t.CallerFormat, e.File, e.Line)
+	b.WriteString(msg)
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// JSONEncoder writes one JSON object per line with the keys ts, level, caller and msg followed
+// by the fields
+type JSONEncoder struct {
+	// TimestampFormat is the time layout of ts, RFC 3339 with nanoseconds by default
+	TimestampFormat string
+}
+
+// Encode implements Encoder
+func (j *JSONEncoder) Encode(e *Entry) []byte {
+	layout := j.TimestampFormat
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	var b bytes.Buffer
+	b.WriteString(`{"ts":`)
+	writeJSON(&b, e.Time.Format(layout))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, strings.ToLower(e.Level.String()))
+	b.WriteString(`,"caller":`)
+	writeJSON(&b, e.File+":"+strconv.Itoa(e.Line))
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, sanitize(e.Message))
+	for i := 0; i < len(e.Fields); i += 2 {
+		key, value := fieldAt(e.Fields, i)
+		b.WriteByte(',')
+		writeJSON(&b, key)
+		b.WriteByte(':')
+		switch v := value.(type) {
+		case error:
+			writeJSON(&b, v.Error())
+		case string:
+			writeJSON(&b, v)
+		default:
+			if data, err := json.Marshal(v); err == nil {
+				b.Write(data)
+			} else {
+				writeJSON(&b, fmt.Sprint(v))
+			}
+		}
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// LogfmtEncoder writes one logfmt line per entry: ts, level, caller and msg followed by the
+// fields, e.g. ts=2024-03-31T09:33:00Z level=info caller=main.go:42 msg="order placed" items=3
+type LogfmtEncoder struct {
+	// TimestampFormat is the time layout of ts, RFC 3339 with nanoseconds by default
+	TimestampFormat string
+}
+
+// Encode implements Encoder
+func (f *LogfmtEncoder) Encode(e *Entry) []byte {
+	layout := f.TimestampFormat
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	var b strings.Builder
+	b.WriteString("ts=")
+	b.WriteString(logfmtValue(e.Time.Format(layout)))
+	b.WriteString(" level=")
+	b.WriteString(strings.ToLower(e.Level.String()))
+	b.WriteString(" caller=")
+	b.WriteString(logfmtValue(e.File + ":" + strconv.Itoa(e.Line)))
+	b.WriteString(" msg=")
+	b.WriteString(logfmtValue(sanitize(e.Message)))
+	if len(e.Fields) > 0 {
+		b.WriteByte(' ')
+		b.WriteString(formatFields(e.Fields))
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+func writeJSON(b *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s)
+	b.Write(data)
+}
+
+// fieldAt returns the key at index i of kv and its value. The values of sensitive keys are
+// masked and a key without a value gets the value (MISSING).
+func fieldAt(kv []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(kv[i])
+	if i+1 >= len(kv) {
+		return key, "(MISSING)"
+	}
+	if isSensitiveKey(key) {
+		return key, "******"
+	}
+	return key, kv[i+1]
+}
+
+// sensitiveKey matches the field keys whose values are masked, once isSensitiveKey has turned
+// them into lower case words separated by underscores: keys whose last word is password, pass,
+// secret or token, and API, access, private and secret keys. For example db_password,
+// search_pass, accessToken and apiKey are masked, cache_key and monkey are not.
+var sensitiveKey = regexp.MustCompile(`^(.*_)?(password|passwd|pass|secret|token|(api|access|private|secret)_?key)$`)
+
+// isSensitiveKey reports whether the value of the field key is masked. Camel case words,
+// dashes and dots are converted to underscore separated words before matching sensitiveKey.
+func isSensitiveKey(key string) bool {
+	var b strings.Builder
+	var prev rune
+	for _, r := range key {
+		switch {
+		case unicode.IsUpper(r):
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case r == '-' || r == '.' || r == ' ':
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return sensitiveKey.MatchString(b.String())
+}
+
+// formatFields renders key/value pairs as logfmt key=value pairs separated by spaces
+func formatFields(kv []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		key, v := fieldAt(kv, i)
+		b.WriteString(logfmtKey(key))
+		b.WriteByte('=')
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		b.WriteString(logfmtValue(fmt.Sprint(v)))
+	}
+	return b.String()
+}
+
+// logfmtKey replaces the characters that cannot appear in a logfmt key with underscores
+func logfmtKey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if needsQuoting(r) {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue quotes and escapes values that contain spaces, control characters, quotes,
+// equal signs or invalid UTF-8. Empty values are written as "".
+func logfmtValue(value string) string {
+	if value == "" || strings.IndexFunc(value, needsQuoting) >= 0 {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+func needsQuoting(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == 0x7f || r == utf8.RuneError
+}
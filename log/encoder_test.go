@@ -0,0 +1,123 @@
+package log
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// testEntry returns an entry logged at a fixed time and caller
+func testEntry(msg string, fields ...interface{}) *Entry {
+	return &Entry{Time: time.Date(2024, 3, 31, 9, 33, 0, 0, time.UTC), Level: INFO, File: "main.go",
+		Line: 42, Message: msg, Fields: fields}
+}
+
+func TestLogfmtEncoder(t *testing.T) {
+	const prefix = "ts=2024-03-31T09:33:00Z level=info caller=main.go:42 "
+	tests := []struct {
+		name  string
+		entry *Entry
+		want  string
+	}{
+		{"plain", testEntry("started", "items", 3), "msg=started items=3"},
+		{"space", testEntry("order placed", "name", "John Smith"), `msg="order placed" name="John Smith"`},
+		{"empty values", testEntry("", "empty", ""), `msg="" empty=""`},
+		{"quotes", testEntry("done", "said", `he said "hi"`), `msg=done said="he said \"hi\""`},
+		{"equal sign", testEntry("done", "query", "a=b"), `msg=done query="a=b"`},
+		{"newlines", testEntry("line 1\nline 2", "value", "a\nb"), `msg="line 1\nline 2" value="a\nb"`},
+		{"tab", testEntry("done", "value", "a\tb"), `msg=done value="a\tb"`},
+		{"invalid UTF-8", testEntry("done", "value", "a\xffb"), `msg=done value="a\xffb"`},
+		{"unicode", testEntry("déjà", "city", "Zürich"), "msg=déjà city=Zürich"},
+		{"key characters", testEntry("done", "a key", 1, "a=b", 2, `"q"`, 3), "msg=done a_key=1 a_b=2 _q_=3"},
+		{"empty key", testEntry("done", "", 1), "msg=done _=1"},
+		{"missing value", testEntry("done", "orphan"), "msg=done orphan=(MISSING)"},
+		{"error", testEntry("failed", "err", errors.New("no such file")), `msg=failed err="no such file"`},
+		{"nil", testEntry("done", "value", nil), "msg=done value=<nil>"},
+		{"masked", testEntry("login", "user", "bob", "password", "s3cret"), "msg=login user=bob password=******"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string((&LogfmtEncoder{}).Encode(tt.entry))
+			if want := prefix + tt.want + "\n"; got != want {
+				t.Errorf("Encode() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestLogfmtEncoderTimestampFormat(t *testing.T) {
+	got := string((&LogfmtEncoder{TimestampFormat: "2006-01-02 15:04"}).Encode(testEntry("done")))
+	if want := "ts=\"2024-03-31 09:33\" level=info caller=main.go:42 msg=done\n"; got != want {
+		t.Errorf("Encode() = %q, want %q", got, want)
+	}
+}
+
+func TestJSONEncoder(t *testing.T) {
+	const prefix = `{"ts":"2024-03-31T09:33:00Z","level":"info","caller":"main.go:42",`
+	tests := []struct {
+		name  string
+		entry *Entry
+		want  string
+	}{
+		{"plain", testEntry("started"), `"msg":"started"}`},
+		{"escaped", testEntry("say \"hi\"\n", "path", `C:\temp`), `"msg":"say \"hi\"\n","path":"C:\\temp"}`},
+		{"types", testEntry("done", "n", 3, "ok", true, "ratio", 0.5, "none", nil),
+			`"msg":"done","n":3,"ok":true,"ratio":0.5,"none":null}`},
+		{"objects", testEntry("done", "ids", []int{1, 2}, "tags", map[string]string{"env": "prod"}),
+			`"msg":"done","ids":[1,2],"tags":{"env":"prod"}}`},
+		{"error", testEntry("failed", "err", errors.New("no such file")), `"msg":"failed","err":"no such file"}`},
+		{"not marshalable", testEntry("done", "ratio", math.Inf(1)), `"msg":"done","ratio":"+Inf"}`},
+		{"non string key", testEntry("done", 1, "one"), `"msg":"done","1":"one"}`},
+		{"missing value", testEntry("done", "orphan"), `"msg":"done","orphan":"(MISSING)"}`},
+		{"masked", testEntry("login", "user", "bob", "apiKey", "abc"), `"msg":"login","user":"bob","apiKey":"******"}`},
+		{"sanitized message", testEntry(`{"password":"s3cret"}`), `"msg":"{\"password\":\"******\"}"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string((&JSONEncoder{}).Encode(tt.entry))
+			if want := prefix + tt.want + "\n"; got != want {
+				t.Errorf("Encode() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestIsSensitiveKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"password", true},
+		{"db_password", true},
+		{"DB_PASSWORD", true},
+		{"dbPassword", true},
+		{"passwd", true},
+		{"search_pass", true},
+		{"secret", true},
+		{"client-secret", true},
+		{"token", true},
+		{"accessToken", true},
+		{"refresh.token", true},
+		{"api_key", true},
+		{"apiKey", true},
+		{"APIKey", true},
+		{"x-api-key", true},
+		{"access_key", true},
+		{"privateKey", true},
+		{"secret_key", true},
+		{"key", false},
+		{"cache_key", false},
+		{"keys", false},
+		{"monkey", false},
+		{"password_policy", false},
+		{"token_count", false},
+		{"passport", false},
+		{"user", false},
+	}
+	for _, tt := range tests {
+		if got := isSensitiveKey(tt.key); got != tt.want {
+			t.Errorf("isSensitiveKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"runtime"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -72,9 +74,10 @@ func (l Level) PaddedString() string {
 	}
 }
 
-// UnmarshalText converts an slice of characters to a Level
+// UnmarshalText converts an slice of characters to a Level. It implements
+// encoding.TextUnmarshaler so that levels can be read from configuration values.
 // nolint:goconst
-func (l *Level) UnmarshalText(text []byte) bool {
+func (l *Level) UnmarshalText(text []byte) error {
 	switch string(bytes.ToUpper(text)) {
 	case "DEBUG":
 		*l = DEBUG
@@ -89,18 +92,16 @@ func (l *Level) UnmarshalText(text []byte) bool {
 	case "FATAL":
 		*l = FATAL
 	default:
-		return false
+		return fmt.Errorf("unknown log level %q", text)
 	}
-	return true
+	return nil
 }
 
-var defaultLogger *CoreLogger
+// defaultLogger is created with the package, so that goroutines never race to create it
+var defaultLogger = New()
 
 // GetDefaultLogger returns the default logger implementation
 func GetDefaultLogger() *CoreLogger {
-	if defaultLogger == nil {
-		defaultLogger = New()
-	}
 	return defaultLogger
 }
 
@@ -110,19 +111,23 @@ type Configurator interface {
 	Output() io.Writer
 	TimestampFormat() string
 	CallerFormat() string
+	// Format is the output format, FormatText, FormatJSON or FormatLogfmt. Empty means
+	// FormatText.
+	Format() string
 }
 
 // Setup is optionally called to configure the logging implementation. If
 // it is not called, the default implementation will log at INFO level to
 // standard output.
 func Setup(config Configurator) {
-	if defaultLogger == nil {
-		defaultLogger = New()
+	var level Level
+	if err := level.UnmarshalText([]byte(config.LogLevel())); err == nil {
+		defaultLogger.SetLevel(level)
 	}
-	defaultLogger.logLevel.UnmarshalText([]byte(config.LogLevel()))
+	defaultLogger.mu.Lock()
 	configOutfile := config.Output()
 	if configOutfile != nil {
-		defaultLogger.outfile = configOutfile
+		defaultLogger.setOutput(configOutfile)
 	}
 	configTimestampFormat := config.TimestampFormat()
 	if configTimestampFormat != "" {
@@ -132,52 +137,91 @@ func Setup(config Configurator) {
 	if configCallerFormat != "" {
 		defaultLogger.callerFormat = configCallerFormat
 	}
+	defaultLogger.mu.Unlock()
+	if err := defaultLogger.SetFormat(config.Format()); err != nil {
+		defaultLogger.Errorf("%v", err)
+	}
 }
 
-// CoreLogger is implements logging
+// CoreLogger is implements logging. It is safe for concurrent use: messages may be logged
+// while the level, output and formats change.
 type CoreLogger struct {
-	logLevel        Level
-	outfile         io.Writer
+	// logLevel holds the Level, it is accessed atomically
+	logLevel int32
+	// mu protects the output and the formats
+	mu      sync.RWMutex
+	outfile io.Writer
+	// outLock serializes the writes to outfile
+	outLock         *outputLock
 	timestampFormat string
 	callerFormat    string
+	// format is the name of the output format and encoder renders it
+	format  string
+	encoder Encoder
+	// parent is the logger whose settings are used by a logger created with With
+	parent *CoreLogger
+	// fields are the key/value pairs added to every message
+	fields []interface{}
 }
 
 // New creates a new CoreLogger
 func New() *CoreLogger {
 	logger := CoreLogger{}
-	logger.logLevel = INFO
-	logger.outfile = os.Stdout
+	logger.logLevel = int32(INFO)
+	logger.setOutput(os.Stdout)
 	logger.timestampFormat = "01-02 15:04:05.000 "
 	logger.callerFormat = " %20.20s:%03d - "
+	logger.format = FormatText
 	return &logger
 }
 
+// With returns a child logger that adds the key/value pairs kv to every message, after the
+// fields of l. The child uses the level, output and formats of l, including later changes.
+// For example:
+//
+//	logger := log.With("request_id", id)
+//	logger.Infow("user created", "user", name)
+func (l *CoreLogger) With(kv ...interface{}) *CoreLogger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &CoreLogger{parent: l.root(), fields: fields}
+}
+
+// root returns the logger that holds the settings of l
+func (l *CoreLogger) root() *CoreLogger {
+	if l.parent != nil {
+		return l.parent
+	}
+	return l
+}
+
 // GetLevel gets the current logging level
 func (l *CoreLogger) GetLevel() Level {
-	return l.logLevel
+	return Level(atomic.LoadInt32(&l.root().logLevel))
 }
 
 // SetLevel sets a filter on the minimum level of messages that will be logged. For
 // example if the level is WARN then no DEBUG or INFO messages will be logged.
 func (l *CoreLogger) SetLevel(level Level) {
-	l.logLevel = level
+	atomic.StoreInt32(&l.root().logLevel, int32(level))
 }
 
 // Fatal logs a message at FATAL level and then calls os.Exit(1)
 func (l *CoreLogger) Fatal(v ...interface{}) {
-	l.log(FATAL, "", v, nil)
+	l.emit(FATAL, "", v, nil)
 	os.Exit(1)
 }
 
 // Fatalf logs a formatted message at FATAL level and then calls os.Exit(1)
 func (l *CoreLogger) Fatalf(format string, v ...interface{}) {
-	l.log(FATAL, format, v, nil)
+	l.emit(FATAL, format, v, nil)
 	os.Exit(1)
 }
 
 // Fatalln logs a message at FATAL level and then calls os.Exit(1)
 func (l *CoreLogger) Fatalln(v ...interface{}) {
-	l.log(FATAL, "", v, nil)
+	l.emit(FATAL, "", v, nil)
 	os.Exit(1)
 }
 
@@ -189,25 +233,25 @@ func (l *CoreLogger) Flags() int {
 // Output writes the output for a logging event. The string s contains
 // the message to log. Calldepth is ignored.
 func (l *CoreLogger) Output(calldepth int, s string) error {
-	l.log(INFO, "", []interface{}{s}, nil)
+	l.emit(INFO, "", []interface{}{s}, nil)
 	return nil
 }
 
 // Panic logs a message at PANIC level and then calls panic().
 func (l *CoreLogger) Panic(v ...interface{}) {
-	l.log(PANIC, "", v, nil)
+	l.emit(PANIC, "", v, nil)
 	panic(fmt.Sprint(v...))
 }
 
 // Panicf logs a formatted message at PANIC level and then calls panic().
 func (l *CoreLogger) Panicf(format string, v ...interface{}) {
-	l.log(PANIC, format, v, nil)
+	l.emit(PANIC, format, v, nil)
 	panic(fmt.Sprintf(format, v...))
 }
 
 // Panicln logs a message and at PANIC level then calls panic().
 func (l *CoreLogger) Panicln(v ...interface{}) {
-	l.log(PANIC, "", v, nil)
+	l.emit(PANIC, "", v, nil)
 	panic(fmt.Sprint(v...))
 }
 
@@ -218,17 +262,17 @@ func (l *CoreLogger) Prefix() string {
 
 // Print logs a message at INFO level.
 func (l *CoreLogger) Print(v ...interface{}) {
-	l.log(INFO, "", v, nil)
+	l.emit(INFO, "", v, nil)
 }
 
 // Printf logs a formatted message at INFO level.
 func (l *CoreLogger) Printf(format string, v ...interface{}) {
-	l.log(INFO, format, v, nil)
+	l.emit(INFO, format, v, nil)
 }
 
 // Println logs a message at INFO level.
 func (l *CoreLogger) Println(v ...interface{}) {
-	l.log(INFO, "", v, nil)
+	l.emit(INFO, "", v, nil)
 }
 
 // SetFlags is not implemented.
@@ -238,7 +282,72 @@ func (l *CoreLogger) SetFlags(flag int) {
 
 // SetOutput sets the io.Writer to which all future log messages will be written.
 func (l *CoreLogger) SetOutput(w io.Writer) {
+	l = l.root()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setOutput(w)
+}
+
+// setOutput replaces the output and its lock. l.mu must be held.
+func (l *CoreLogger) setOutput(w io.Writer) {
+	lock := acquireOutputLock(w)
+	if l.outLock != nil {
+		releaseOutputLock(l.outfile, l.outLock)
+	}
 	l.outfile = w
+	l.outLock = lock
+}
+
+// SetFormat selects the output format, FormatText, FormatJSON or FormatLogfmt. Empty means
+// FormatText. A custom encoder is replaced when the format changes.
+func (l *CoreLogger) SetFormat(format string) error {
+	switch format {
+	case "", FormatText, FormatJSON, FormatLogfmt:
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	if format == "" {
+		format = FormatText
+	}
+	l = l.root()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.format != format {
+		l.format = format
+		l.encoder = nil
+	}
+	return nil
+}
+
+// SetEncoder replaces the encoder of the output format with a custom one
+func (l *CoreLogger) SetEncoder(encoder Encoder) {
+	l = l.root()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.encoder = encoder
+}
+
+// output returns the output, its lock and the encoder to use for a message. The lock must be
+// released with releaseOutputLock once the message is written.
+func (l *CoreLogger) output() (io.Writer, *outputLock, Encoder) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.outfile, acquireOutputLock(l.outfile), l.getEncoder()
+}
+
+// getEncoder returns the custom encoder or the encoder of the output format. l.mu must be
+// held.
+func (l *CoreLogger) getEncoder() Encoder {
+	if l.encoder != nil {
+		return l.encoder
+	}
+	switch l.format {
+	case FormatJSON:
+		return &JSONEncoder{}
+	case FormatLogfmt:
+		return &LogfmtEncoder{}
+	}
+	return &TextEncoder{TimestampFormat: l.timestampFormat, CallerFormat: l.callerFormat}
 }
 
 // SetPrefix is not implemented.
@@ -250,26 +359,56 @@ func (l *CoreLogger) SetPrefix(prefix string) {
 
 // Debugf logs a formatted message at DEBUG level.
 func (l *CoreLogger) Debugf(format string, args ...interface{}) {
-	l.log(DEBUG, format, args, nil)
+	l.emit(DEBUG, format, args, nil)
 }
 
 // Infof logs a formatted message at INFO level.
 func (l *CoreLogger) Infof(format string, args ...interface{}) {
-	l.log(INFO, format, args, nil)
+	l.emit(INFO, format, args, nil)
 }
 
 // Warnf logs a formatted message at WARN level.
 func (l *CoreLogger) Warnf(format string, args ...interface{}) {
-	l.log(WARN, format, args, nil)
+	l.emit(WARN, format, args, nil)
 }
 
 // Errorf logs a formatted message at ERROR level.
 func (l *CoreLogger) Errorf(format string, args ...interface{}) {
-	l.log(ERROR, format, args, nil)
+	l.emit(ERROR, format, args, nil)
+}
+
+// Debugw logs a message at DEBUG level with the key/value pairs kv.
+func (l *CoreLogger) Debugw(msg string, kv ...interface{}) {
+	l.emit(DEBUG, "%s", []interface{}{msg}, kv)
+}
+
+// Infow logs a message at INFO level with the key/value pairs kv.
+func (l *CoreLogger) Infow(msg string, kv ...interface{}) {
+	l.emit(INFO, "%s", []interface{}{msg}, kv)
+}
+
+// Warnw logs a message at WARN level with the key/value pairs kv.
+func (l *CoreLogger) Warnw(msg string, kv ...interface{}) {
+	l.emit(WARN, "%s", []interface{}{msg}, kv)
+}
+
+// Errorw logs a message at ERROR level with the key/value pairs kv.
+func (l *CoreLogger) Errorw(msg string, kv ...interface{}) {
+	l.emit(ERROR, "%s", []interface{}{msg}, kv)
+}
+
+// emit logs a message from a method of CoreLogger. It adds a stack frame so that the methods
+// report the same caller as the package functions.
+func (l *CoreLogger) emit(level Level, format string, args []interface{}, context []interface{}) {
+	l.log(level, format, args, context)
 }
 
 func (l *CoreLogger) log(level Level, format string, args []interface{}, context []interface{}) {
-	if level < l.logLevel {
+	if len(l.fields) > 0 {
+		context = append(l.fields[:len(l.fields):len(l.fields)], context...)
+	}
+	l = l.root()
+	if level < l.GetLevel() {
 		return
 	}
 	_, file, line, ok := runtime.Caller(3)
@@ -286,15 +425,62 @@ func (l *CoreLogger) log(level Level, format string, args []interface{}, context
 		msg = fmt.Sprintf(format, args...)
 	}
 
-	msg = sanitize(msg)
+	entry := Entry{Time: time.Now(), Level: level, File: file, Line: line, Message: msg, Fields: context}
+	out, lock, encoder := l.output()
+	b := encoder.Encode(&entry)
+	lock.Lock()
+	_, _ = out.Write(b)
+	lock.Unlock()
+	releaseOutputLock(out, lock)
+}
+
+// outputLock serializes the writes to an output, so that the messages of the loggers sharing
+// it are written one at a time and never interleave. refs counts the loggers using the output
+// and the messages being written to it.
+type outputLock struct {
+	sync.Mutex
+	refs int
+}
+
+var (
+	// outputLocks holds the lock of every output used by a logger. Loggers with different
+	// outputs do not wait for each other.
+	outputLocks     = make(map[io.Writer]*outputLock)
+	outputLocksLock sync.Mutex
+	// sharedLock serializes the writes to outputs that cannot be map keys
+	sharedLock outputLock
+)
+
+// acquireOutputLock returns the lock of the output w for a logger that starts using it or a
+// message about to be written to it
+func acquireOutputLock(w io.Writer) *outputLock {
+	if w == nil || !reflect.TypeOf(w).Comparable() {
+		return &sharedLock
+	}
+	outputLocksLock.Lock()
+	defer outputLocksLock.Unlock()
+	lock, ok := outputLocks[w]
+	if !ok {
+		lock = &outputLock{}
+		outputLocks[w] = lock
+	}
+	lock.refs++
+	return lock
+}
 
-	var b strings.Builder
-	b.WriteString(time.Now().Format(l.timestampFormat))
-	b.WriteString(level.PaddedString())
-	_, _ = fmt.Fprintf(&b, l.callerFormat, file, line)
-	b.WriteString(msg)
-	b.WriteString("\n")
-	_, _ = l.outfile.Write([]byte(b.String()))
+// releaseOutputLock is called when a logger stops using the output w or a message has been
+// written to it. The lock is forgotten once the output is no longer used, so that outputs can
+// be garbage collected.
+func releaseOutputLock(w io.Writer, lock *outputLock) {
+	if lock == &sharedLock {
+		return
+	}
+	outputLocksLock.Lock()
+	defer outputLocksLock.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(outputLocks, w)
+	}
 }
 
 var matchers = []*regexp.Regexp{
@@ -317,9 +503,6 @@ func sanitize(msg string) string {
 }
 
 func log(level Level, format string, args []interface{}, context []interface{}) {
-	if defaultLogger == nil {
-		defaultLogger = New()
-	}
 	defaultLogger.log(level, format, args, context)
 }
 
@@ -400,10 +583,7 @@ func SetFlags(flag int) {
 
 // SetOutput sets the io.Writer to which all future log messages will be written.
 func SetOutput(w io.Writer) {
-	if defaultLogger == nil {
-		defaultLogger = New()
-	}
-	defaultLogger.outfile = w
+	defaultLogger.SetOutput(w)
 }
 
 // SetPrefix is not implemented.
@@ -413,13 +593,21 @@ func SetPrefix(prefix string) {
 
 // extensions to standard go library
 
+// SetFormat selects the output format of the default logger, FormatText, FormatJSON or
+// FormatLogfmt
+func SetFormat(format string) error {
+	return GetDefaultLogger().SetFormat(format)
+}
+
+// SetEncoder replaces the encoder of the default logger with a custom one
+func SetEncoder(encoder Encoder) {
+	GetDefaultLogger().SetEncoder(encoder)
+}
+
 // SetLevel sets a filter on the minimum level of messages that will be logged. For
 // example if the level is WARN then no DEBUG or INFO messages will be logged.
 func SetLevel(level Level) {
-	if defaultLogger == nil {
-		defaultLogger = New()
-	}
-	defaultLogger.logLevel = level
+	defaultLogger.SetLevel(level)
 }
 
 // Debugf logs a formatted message at DEBUG level.
@@ -441,3 +629,32 @@ func Warnf(format string, args ...interface{}) {
 func Errorf(format string, args ...interface{}) {
 	log(ERROR, format, args, nil)
 }
+
+// Debugw logs a message at DEBUG level with the key/value pairs kv, rendered as key=value.
+func Debugw(msg string, kv ...interface{}) {
+	log(DEBUG, "%s", []interface{}{msg}, kv)
+}
+
+// Infow logs a message at INFO level with the key/value pairs kv, rendered as key=value.
+// For example:
+//
+//	log.Infow("request served", "path", r.URL.Path, "status", 200)
+func Infow(msg string, kv ...interface{}) {
+	log(INFO, "%s", []interface{}{msg}, kv)
+}
+
+// Warnw logs a message at WARN level with the key/value pairs kv, rendered as key=value.
+func Warnw(msg string, kv ...interface{}) {
+	log(WARN, "%s", []interface{}{msg}, kv)
+}
+
+// Errorw logs a message at ERROR level with the key/value pairs kv, rendered as key=value.
+func Errorw(msg string, kv ...interface{}) {
+	log(ERROR, "%s", []interface{}{msg}, kv)
+}
+
+// With returns a child of the default logger that adds the key/value pairs kv to every
+// message
+func With(kv ...interface{}) *CoreLogger {
+	return GetDefaultLogger().With(kv...)
+}
@@ -0,0 +1,175 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testConfigurator struct {
+	level  string
+	output io.Writer
+	format string
+}
+
+func (c testConfigurator) LogLevel() string        { return c.level }
+func (c testConfigurator) Output() io.Writer       { return c.output }
+func (c testConfigurator) TimestampFormat() string { return "" }
+func (c testConfigurator) CallerFormat() string    { return "" }
+func (c testConfigurator) Format() string          { return c.format }
+
+// TestConcurrentLogging logs from many goroutines while the level, output and format of the
+// loggers change. Run it with -race.
+func TestConcurrentLogging(t *testing.T) {
+	const goroutines = 8
+	const messages = 200
+
+	var outputs [2]bytes.Buffer
+	logger := New()
+	logger.SetOutput(&outputs[0])
+	SetOutput(&outputs[1])
+	defer Setup(testConfigurator{level: "INFO", output: os.Stdout, format: FormatText})
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			child := logger.With("component", "test")
+			for j := 0; j < messages; j++ {
+				logger.Infof("message %d", j)
+				child.Infow("message", "j", j)
+				Infof("message %d", j)
+				With("component", "test").Infow("message", "j", j)
+				Debugf("debug message dropped unless the level is DEBUG")
+			}
+		}()
+	}
+
+	stop := make(chan struct{})
+	var changes sync.WaitGroup
+	changes.Add(1)
+	go func() {
+		defer changes.Done()
+		formats := []string{FormatText, FormatJSON, FormatLogfmt}
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			out := &outputs[i%2]
+			logger.SetOutput(out)
+			logger.SetLevel(Level(i%2) - 1)
+			_ = logger.SetFormat(formats[i%3])
+			Setup(testConfigurator{level: Level(i%2 - 1).String(), output: out, format: formats[(i+1)%3]})
+			_ = GetDefaultLogger().GetLevel()
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	changes.Wait()
+
+	infos := 0
+	for i := range outputs {
+		for _, line := range strings.SplitAfter(outputs[i].String(), "\n") {
+			if line == "" {
+				continue
+			}
+			if !strings.HasSuffix(line, "\n") || strings.Count(line, "message") != 1 {
+				t.Fatalf("interleaved line %q", line)
+			}
+			if !strings.Contains(line, "dropped") {
+				infos++
+			}
+		}
+	}
+	if want := 4 * goroutines * messages; infos != want {
+		t.Errorf("logged %d INFO messages, want %d", infos, want)
+	}
+}
+
+// blockingWriter blocks every write until release is closed
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(b []byte) (int, error) {
+	close(w.started)
+	<-w.release
+	return len(b), nil
+}
+
+func TestOutputsDoNotBlockEachOther(t *testing.T) {
+	blocked := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	slow := New()
+	slow.SetOutput(blocked)
+	go slow.Infof("blocked")
+	<-blocked.started
+	defer close(blocked.release)
+
+	var out bytes.Buffer
+	fast := New()
+	fast.SetOutput(&out)
+	done := make(chan struct{})
+	go func() {
+		fast.Infof("not blocked")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a logger waited for the output of another logger")
+	}
+}
+
+func TestOutputLocksReleased(t *testing.T) {
+	var first, second bytes.Buffer
+	loggers := []*CoreLogger{New(), New()}
+	for _, logger := range loggers {
+		logger.SetOutput(&first)
+	}
+	loggers[0].SetOutput(&second)
+	if !hasOutputLock(&first) {
+		t.Fatal("the lock of an output still in use was released")
+	}
+	loggers[1].SetOutput(&second)
+	if hasOutputLock(&first) {
+		t.Error("the lock of an output no longer used was kept")
+	}
+	for _, logger := range loggers {
+		logger.SetOutput(os.Stdout)
+	}
+	if hasOutputLock(&second) {
+		t.Error("the lock of an output no longer used was kept")
+	}
+}
+
+func hasOutputLock(w io.Writer) bool {
+	outputLocksLock.Lock()
+	defer outputLocksLock.Unlock()
+	_, ok := outputLocks[w]
+	return ok
+}
+
+func TestSetLevel(t *testing.T) {
+	var out bytes.Buffer
+	logger := New()
+	logger.SetOutput(&out)
+	child := logger.With("k", "v")
+
+	child.SetLevel(WARN)
+	if level := logger.GetLevel(); level != WARN {
+		t.Errorf("GetLevel() = %v, want the level set on a child, WARN", level)
+	}
+	logger.Infof("hidden")
+	child.Warnf("shown")
+	if s := out.String(); strings.Contains(s, "hidden") || !strings.Contains(s, "shown k=v") {
+		t.Errorf("output = %q, want only the WARN message with its field", s)
+	}
+}